@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"nsi/internal/app"
@@ -22,11 +23,24 @@ func main() {
 
 	log := setupLogger(cfg.Env)
 
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		os.Exit(migrate(log, cfg, args[1:]))
+	}
+
 	log.Info("start application", slog.String("mode", cfg.Env))
 
 	application := app.New(log, cfg)
 
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+
 	go application.HttpServer.Run()
+	if application.Relay != nil {
+		go application.Relay.Run(relayCtx)
+	}
+	if application.Provisioner != nil {
+		go application.Provisioner.Run(relayCtx)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -40,6 +54,12 @@ func main() {
 	} else {
 		fmt.Println("Server gracefully stopped")
 	}
+
+	stopRelay()
+
+	if err := application.Events.Close(); err != nil {
+		fmt.Printf("Events publisher close error: %v\n", err)
+	}
 }
 
 func setupLogger(env string) *slog.Logger {
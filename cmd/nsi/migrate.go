@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"nsi/internal/config"
+	psql "nsi/internal/storage"
+	"strconv"
+)
+
+// migrate runs `nsi migrate up|down [steps]|status` and returns the process exit code.
+func migrate(log *slog.Logger, cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Println("usage: nsi migrate up|down [steps]|status")
+		return 2
+	}
+
+	storage, err := psql.New(log, cfg.PSQL_Connect)
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := storage.MigrateUp(ctx)
+		if err != nil {
+			log.Error(err.Error())
+			return 1
+		}
+		fmt.Printf("applied %v migration(s)\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Println("steps must be a positive number")
+				return 2
+			}
+		}
+
+		reverted, err := storage.MigrateDown(ctx, steps)
+		if err != nil {
+			log.Error(err.Error())
+			return 1
+		}
+		fmt.Printf("reverted %v migration(s)\n", reverted)
+	case "status":
+		status, err := storage.MigrationStatus(ctx)
+		if err != nil {
+			log.Error(err.Error())
+			return 1
+		}
+
+		for _, item := range status {
+			applied := "pending"
+			if item.AppliedAt != nil {
+				applied = item.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30v %v\n", item.Version, item.Name, applied)
+		}
+	default:
+		fmt.Println("usage: nsi migrate up|down [steps]|status")
+		return 2
+	}
+
+	return 0
+}
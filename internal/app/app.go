@@ -1,13 +1,20 @@
 package app
 
 import (
+	"context"
 	"log/slog"
 	grpc_client "nsi/internal/app/grpc"
 	httpapp "nsi/internal/app/http"
 	grpcHandler "nsi/internal/auth"
 	"nsi/internal/config"
+	"nsi/internal/events"
+	producer "nsi/internal/kafka"
+	"nsi/internal/provisioning"
+	"nsi/internal/realtime"
 	"nsi/internal/services/dashboard"
+	"nsi/internal/services/group"
 	grpcService "nsi/internal/services/grpc"
+	"nsi/internal/services/history"
 	"nsi/internal/services/rights"
 	"nsi/internal/services/widget"
 	psql "nsi/internal/storage"
@@ -15,6 +22,10 @@ import (
 
 type App struct {
 	HttpServer *httpapp.App
+	Relay      *producer.Relay // nil when Kafka is disabled
+	Events     events.Publisher
+
+	Provisioner *provisioning.Provisioner // nil when provisioning is disabled
 }
 
 func New(log *slog.Logger, cfg *config.Config) *App {
@@ -23,19 +34,52 @@ func New(log *slog.Logger, cfg *config.Config) *App {
 		panic(err)
 	}
 
+	if cfg.AutoMigrate {
+		applied, err := storage.MigrateUp(context.Background())
+		if err != nil {
+			panic(err)
+		}
+		log.Info("migrations applied", slog.Int("count", applied))
+	}
+
+	hub := realtime.NewHub(log)
+
+	// the hub gets events as soon as their change commits and never sees the relay retrying, the outbox and its
+	// relay only exist to feed the broker
+	var publisher events.Publisher = events.Noop{}
+	var emitted events.Publisher = events.NewAfterCommit(hub, storage)
+	var relay *producer.Relay
+	if cfg.Kafka.Enabled {
+		publisher = producer.New(log, cfg.Kafka.Brokers)
+		relay = producer.NewRelay(log, storage, publisher, cfg.Kafka.RelayInterval)
+		emitted = events.NewMulti(events.NewOutbox(storage), emitted)
+	}
+	dispatcher := events.NewDispatcher(emitted, storage)
+
 	grpcClient := grpc_client.New(log, cfg.Client.Port)
 	grpcClient.Run()
 	grpcservice := grpcService.New(log, grpcClient)
 
 	grpcHandler := grpcHandler.NewHandler(grpcservice)
 
-	dashboardService := dashboard.New(log, storage, storage, storage, storage)
-	widgetService := widget.New(log, storage, storage, storage, storage)
-	rightsService := rights.New(log, storage, storage, storage, storage)
+	historyService := history.New(log, storage, storage, storage, storage, dispatcher)
+	dashboardService := dashboard.New(log, storage, storage, storage, storage, storage, storage, historyService, dispatcher)
+	widgetService := widget.New(log, storage, storage, storage, storage, storage, historyService, dispatcher)
+	rightsService := rights.New(log, storage, storage, storage, storage, storage, dispatcher)
+	groupService := group.New(log, storage, storage, storage, storage, storage)
+
+	var provisioner *provisioning.Provisioner
+	if cfg.Provisioning.Enabled {
+		provisioner = provisioning.New(log, storage, rightsService, historyService, dispatcher, cfg.Provisioning.Path, cfg.Provisioning.Interval)
+	}
 
-	server := httpapp.New(log, cfg.Server.Port, cfg.Server.Timeout, rightsService, grpcHandler, grpcservice, dashboardService, widgetService)
+	server := httpapp.New(log, cfg.Server.Port, cfg.Server.Timeout, rightsService, grpcHandler, grpcservice, dashboardService, widgetService, groupService, historyService, hub)
 
 	return &App{
 		HttpServer: server,
+		Relay:      relay,
+		Events:     events.NewMulti(publisher, hub),
+
+		Provisioner: provisioner,
 	}
 }
@@ -7,11 +7,17 @@ import (
 	"net/http"
 	grpcHandler "nsi/internal/auth"
 	dashboardController "nsi/internal/http/dashboard"
+	groupController "nsi/internal/http/group"
+	historyController "nsi/internal/http/history"
 	rightsController "nsi/internal/http/rights"
 	userController "nsi/internal/http/user"
 	widgetController "nsi/internal/http/widget"
+	wsController "nsi/internal/http/ws"
+	"nsi/internal/realtime"
 	"nsi/internal/services/dashboard"
+	"nsi/internal/services/group"
 	grpcService "nsi/internal/services/grpc"
+	"nsi/internal/services/history"
 	"nsi/internal/services/rights"
 	"nsi/internal/services/widget"
 	"time"
@@ -27,18 +33,22 @@ type App struct {
 	port   int
 }
 
-func New(log *slog.Logger, port int, timeout time.Duration, rights *rights.Service, grpc *grpcHandler.Handler, gservice *grpcService.Service, ds *dashboard.Service, ws *widget.Service) *App {
+func New(log *slog.Logger, port int, timeout time.Duration, rights *rights.Service, grpc *grpcHandler.Handler, gservice *grpcService.Service, ds *dashboard.Service, ws *widget.Service, gs *group.Service, hs *history.Service, hub *realtime.Hub) *App {
 	mux := http.NewServeMux()
-	dashboardController.Register(log, mux, timeout, grpc, ds, rights)
+	dashboardController.Register(log, mux, timeout, grpc, ds, rights, hub)
 	widgetController.Register(log, mux, timeout, grpc, ws, rights)
 	userController.Register(log, mux, timeout, grpc, gservice)
-	rightsController.Register(log, mux, timeout, grpc, rights, rights)
+	rightsController.Register(log, mux, timeout, grpc, rights, rights, gs)
+	groupController.Register(log, mux, timeout, grpc, gs)
+	historyController.Register(log, mux, timeout, grpc, hs, rights)
+	wsController.Register(log, mux, timeout, grpc, hub, rights)
 
 	return &App{log, mux, nil, port}
 }
 
 func (app *App) Run() {
-	handler := govisual.Wrap(app.mux, govisual.WithRequestBodyLogging(true), govisual.WithResponseBodyLogging(true))
+	// long-lived streams are kept out of govisual, its response writer can neither hijack nor flush
+	handler := govisual.Wrap(app.mux, govisual.WithRequestBodyLogging(true), govisual.WithResponseBodyLogging(true), govisual.WithIgnorePaths("/ws", "/dashboard/*/events"))
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"}, //not safe
@@ -8,6 +8,9 @@ import (
 	"time"
 )
 
+// ShareTokenHeader carries a share token, ShareHandler also accepts it as the "share" query parameter.
+const ShareTokenHeader = "X-Share-Token"
+
 type Handler struct {
 	service *grpcService.Service
 }
@@ -32,7 +35,50 @@ func (handler *Handler) ValidateHandler(next http.HandlerFunc) http.HandlerFunc
 			return
 		}
 
-		r.Header.Add("UserId", strconv.Itoa(int(resp.UserId)))
+		r.Header.Set("UserId", strconv.Itoa(int(resp.UserId)))
+
+		next(w, r)
+	}
+}
+
+// StreamHandler is ValidateHandler for websocket and EventSource routes, browsers cannot set headers on those
+// so the session may also come as the "token" query parameter.
+func (handler *Handler) StreamHandler(next http.HandlerFunc) http.HandlerFunc {
+	validate := handler.ValidateHandler(next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", token)
+		}
+
+		validate(w, r)
+	}
+}
+
+// ShareHandler is ValidateHandler for routes reachable by share link: a request without a session is let
+// through anonymously as long as it presents a share token, rights are then checked against the token.
+func (handler *Handler) ShareHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("UserId")
+
+		if token := r.URL.Query().Get("share"); token != "" {
+			r.Header.Set(ShareTokenHeader, token)
+		}
+
+		if r.Header.Get(ShareTokenHeader) == "" {
+			handler.ValidateHandler(next)(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "" {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			resp, err := handler.service.ValidateToken(ctx, r.Header.Get("Authorization"))
+			if err == nil && resp.IsValid {
+				r.Header.Set("UserId", strconv.Itoa(int(resp.UserId)))
+			}
+		}
 
 		next(w, r)
 	}
@@ -9,10 +9,13 @@ import (
 )
 
 type Config struct {
-	Env          string       `yaml:"env"`
-	PSQL_Connect string       `yaml:"psql_connect"`
-	Server       ServerConfig `yaml:"server"`
-	Client       ClientConfig `yaml:"client"`
+	Env          string             `yaml:"env"`
+	PSQL_Connect string             `yaml:"psql_connect"`
+	AutoMigrate  bool               `yaml:"auto_migrate" env-default:"false"`
+	Server       ServerConfig       `yaml:"server"`
+	Client       ClientConfig       `yaml:"client"`
+	Kafka        KafkaConfig        `yaml:"kafka"`
+	Provisioning ProvisioningConfig `yaml:"provisioning"`
 }
 
 type ServerConfig struct {
@@ -26,6 +29,21 @@ type ClientConfig struct {
 	Address string `yaml:"addr"`
 }
 
+// KafkaConfig selects the event publisher, events are dropped when Kafka is disabled.
+type KafkaConfig struct {
+	Enabled       bool          `yaml:"enabled" env-default:"false"`
+	Brokers       []string      `yaml:"brokers"`
+	RelayInterval time.Duration `yaml:"relay_interval" env-default:"1s"`
+}
+
+// ProvisioningConfig applies the dashboard definitions (YAML or JSON files) under Path at startup and checks them
+// for changes every Interval.
+type ProvisioningConfig struct {
+	Enabled  bool          `yaml:"enabled" env-default:"false"`
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval" env-default:"30s"`
+}
+
 func Load() *Config {
 	path := fetchConfigPath()
 	if path == "" {
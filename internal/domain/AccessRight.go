@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type GrantType string
 
 const (
@@ -14,10 +16,16 @@ func (t GrantType) ToInt() int {
 	return ranks[t]
 }
 
+func ParseGrantType(value string) (GrantType, bool) {
+	_, ok := ranks[GrantType(value)]
+	return GrantType(value), ok
+}
+
 type AccessRight struct {
 	Id          int
 	UserId      *int
 	UserGroupId *int
 	AccessToken *string
+	ExpiresAt   *time.Time
 	Type        GrantType
 }
@@ -0,0 +1,106 @@
+package models
+
+import "math"
+
+const (
+	DefaultWidgetWidth  = 4
+	DefaultWidgetHeight = 3
+
+	maxGridColumns   = 48
+	maxGridRowHeight = 1000
+)
+
+// WidgetLayout places a widget on its dashboard, in grid cells (columns by rows of RowHeight).
+// Z orders overlapping widgets, a Locked widget cannot be moved or resized until it is unlocked.
+type WidgetLayout struct {
+	X      float64
+	Y      float64
+	W      float64
+	H      float64
+	Z      int
+	Locked bool
+}
+
+// LayoutChange carries the layout fields of a request, nil ones keep their current value.
+type LayoutChange struct {
+	X      *float64
+	Y      *float64
+	W      *float64
+	H      *float64
+	Z      *int
+	Locked *bool
+}
+
+// WidgetLayoutUpdate is one widget of a bulk layout change, Version 0 applies it regardless of the widget version.
+type WidgetLayoutUpdate struct {
+	WidgetId int
+	LayoutChange
+	Version int
+}
+
+// GridSettings is the dashboard layout grid. Snap rounds layouts to whole cells, Collision rejects overlaps.
+type GridSettings struct {
+	Columns   int
+	RowHeight int
+	Snap      bool
+	Collision bool
+}
+
+var DefaultGrid = GridSettings{Columns: 12, RowHeight: 30, Snap: true, Collision: true}
+
+func (c LayoutChange) Apply(layout WidgetLayout) WidgetLayout {
+	if c.X != nil {
+		layout.X = *c.X
+	}
+	if c.Y != nil {
+		layout.Y = *c.Y
+	}
+	if c.W != nil {
+		layout.W = *c.W
+	}
+	if c.H != nil {
+		layout.H = *c.H
+	}
+	if c.Z != nil {
+		layout.Z = *c.Z
+	}
+	if c.Locked != nil {
+		layout.Locked = *c.Locked
+	}
+	return layout
+}
+
+// SameArea reports whether both layouts cover the same cells, regardless of z-order and lock.
+func (l WidgetLayout) SameArea(other WidgetLayout) bool {
+	return l.X == other.X && l.Y == other.Y && l.W == other.W && l.H == other.H
+}
+
+func (l WidgetLayout) Overlaps(other WidgetLayout) bool {
+	return l.X < other.X+other.W && other.X < l.X+l.W && l.Y < other.Y+other.H && other.Y < l.Y+l.H
+}
+
+func (g GridSettings) Validate() error {
+	if g.Columns < 1 || g.Columns > maxGridColumns || g.RowHeight < 1 || g.RowHeight > maxGridRowHeight {
+		return ErrInvalidLayout
+	}
+	return nil
+}
+
+// Fit snaps the layout to whole cells when enabled. Snapped or not, the layout is kept right of and below the
+// origin and within the grid's columns.
+func (g GridSettings) Fit(layout WidgetLayout) (WidgetLayout, error) {
+	if g.Snap {
+		layout.X, layout.Y = math.Round(layout.X), math.Round(layout.Y)
+		layout.W, layout.H = math.Round(layout.W), math.Round(layout.H)
+	}
+
+	if layout.X < 0 || layout.Y < 0 || layout.W <= 0 || layout.H <= 0 {
+		return layout, ErrInvalidLayout
+	}
+
+	if layout.X+layout.W > float64(g.Columns) {
+		return layout, ErrInvalidLayout
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,8 @@
+package models
+
+type OutboxMessage struct {
+	Id       int64
+	Topic    string
+	Payload  []byte
+	Attempts int
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type PresenceState string
+
+const (
+	PresenceViewing PresenceState = "viewing"
+	PresenceEditing PresenceState = "editing"
+)
+
+type Pointer struct {
+	X float64
+	Y float64
+}
+
+// PresenceMember is one open connection on a dashboard, a user with two tabs shows up under two SessionIds.
+type PresenceMember struct {
+	SessionId string
+	UserId    int
+	State     PresenceState
+	Pointer   *Pointer
+	Selection []int
+	JoinedAt  time.Time
+	LastSeen  time.Time
+}
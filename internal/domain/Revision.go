@@ -0,0 +1,17 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Revision is the state of a dashboard and its widgets right after a change. Version counts the revisions of the
+// dashboard from 1, Snapshot is an export document of the dashboard without its children and left out of listings.
+type Revision struct {
+	Version     int
+	DashboardId int
+	AuthorId    int
+	Change      string // the event type of the change, e.g. widget_update_config
+	CreatedAt   time.Time
+	Snapshot    json.RawMessage `json:",omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+type UserGroup struct {
+	Id      int
+	Name    string
+	OwnerId int
+}
+
+type UserGroupMember struct {
+	GroupId int
+	UserId  int
+}
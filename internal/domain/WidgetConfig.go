@@ -0,0 +1,110 @@
+package models
+
+// Typed configs of the built-in widget types. They mirror widgettypes/<type>.schema.json, which stays the source
+// of truth for validation, and their zero-ish values from widgetTypeDefaults are what a widget starts with. Their
+// fields are checked against the schema properties at startup and every accepted config is decoded into them.
+
+type Axis struct {
+	Label string   `json:"label,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+type Series struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Color string `json:"color,omitempty"`
+}
+
+type Threshold struct {
+	Value float64 `json:"value"`
+	Color string  `json:"color"`
+}
+
+type TableColumn struct {
+	Field  string `json:"field"`
+	Header string `json:"header,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+type SquareConfig struct {
+	Color string `json:"color,omitempty"`
+}
+
+type LineChartConfig struct {
+	Title      string   `json:"title"`
+	Series     []Series `json:"series"`
+	XAxis      Axis     `json:"xAxis"`
+	YAxis      Axis     `json:"yAxis"`
+	ShowLegend bool     `json:"showLegend"`
+	Smooth     bool     `json:"smooth"`
+}
+
+type BarChartConfig struct {
+	Title      string   `json:"title"`
+	Series     []Series `json:"series"`
+	XAxis      Axis     `json:"xAxis"`
+	YAxis      Axis     `json:"yAxis"`
+	ShowLegend bool     `json:"showLegend"`
+	Stacked    bool     `json:"stacked"`
+	Horizontal bool     `json:"horizontal"`
+}
+
+type PieChartConfig struct {
+	Title      string `json:"title"`
+	Query      string `json:"query"`
+	LabelField string `json:"labelField"`
+	ValueField string `json:"valueField"`
+	Donut      bool   `json:"donut"`
+	ShowLegend bool   `json:"showLegend"`
+}
+
+type TableConfig struct {
+	Title    string        `json:"title"`
+	Query    string        `json:"query"`
+	Columns  []TableColumn `json:"columns"`
+	PageSize int           `json:"pageSize"`
+	Sortable bool          `json:"sortable"`
+}
+
+type MarkdownTextConfig struct {
+	Content string `json:"content"`
+}
+
+type SingleStatConfig struct {
+	Title      string      `json:"title"`
+	Query      string      `json:"query"`
+	Unit       string      `json:"unit,omitempty"`
+	Decimals   int         `json:"decimals"`
+	Thresholds []Threshold `json:"thresholds"`
+}
+
+type ImageConfig struct {
+	Url string `json:"url"`
+	Alt string `json:"alt,omitempty"`
+	Fit string `json:"fit"`
+}
+
+// widgetTypeDefaults is the starting config of each type, a widget created without config gets it.
+var widgetTypeDefaults = map[WidgetType]any{
+	Square:       SquareConfig{},
+	LineChart:    LineChartConfig{Series: []Series{}, ShowLegend: true},
+	BarChart:     BarChartConfig{Series: []Series{}, ShowLegend: true},
+	PieChart:     PieChartConfig{LabelField: "label", ValueField: "value", ShowLegend: true},
+	Table:        TableConfig{Columns: []TableColumn{}, PageSize: 25, Sortable: true},
+	MarkdownText: MarkdownTextConfig{},
+	SingleStat:   SingleStatConfig{Thresholds: []Threshold{}},
+	Image:        ImageConfig{Fit: "contain"},
+}
+
+// newWidgetConfig returns a pointer to an empty typed config, used by ValidateConfig.
+var newWidgetConfig = map[WidgetType]func() any{
+	Square:       func() any { return &SquareConfig{} },
+	LineChart:    func() any { return &LineChartConfig{} },
+	BarChart:     func() any { return &BarChartConfig{} },
+	PieChart:     func() any { return &PieChartConfig{} },
+	Table:        func() any { return &TableConfig{} },
+	MarkdownText: func() any { return &MarkdownTextConfig{} },
+	SingleStat:   func() any { return &SingleStatConfig{} },
+	Image:        func() any { return &ImageConfig{} },
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// widgetTypeFiles holds one JSON Schema per widget type, named <type>.schema.json. Adding a type also needs
+// a typed config with a default in WidgetConfig.go and a value in the widgetType enum (see the storage migrations).
+//
+//go:embed widgettypes/*.schema.json
+var widgetTypeFiles embed.FS
+
+var ErrUnknownWidgetType = errors.New("unknown widget type")
+
+type WidgetTypeInfo struct {
+	Type    WidgetType
+	Schema  json.RawMessage
+	Default json.RawMessage
+}
+
+type FieldError struct {
+	Field   string // JSON pointer into the config, "" for the config as a whole
+	Message string
+}
+
+// ConfigError lists every violation of the widget type schema found in a config.
+type ConfigError struct {
+	Errors []FieldError
+}
+
+func (e *ConfigError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, item := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%v: %v", item.Field, item.Message))
+	}
+	return "invalid widget config: " + strings.Join(messages, "; ")
+}
+
+type widgetTypeEntry struct {
+	info   WidgetTypeInfo
+	schema *jsonschema.Schema
+}
+
+var widgetTypes = loadWidgetTypes()
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+// loadWidgetTypes compiles the embedded schemas, a broken one is a programming error and panics at startup.
+func loadWidgetTypes() map[WidgetType]widgetTypeEntry {
+	entries, err := fs.ReadDir(widgetTypeFiles, "widgettypes")
+	if err != nil {
+		panic(err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	result := make(map[WidgetType]widgetTypeEntry, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+
+		raw, err := widgetTypeFiles.ReadFile("widgettypes/" + name)
+		if err != nil {
+			panic(err)
+		}
+
+		doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(raw)))
+		if err != nil {
+			panic(fmt.Errorf("widget type schema %v: %w", name, err))
+		}
+
+		url := "widgettypes/" + name
+		if err := compiler.AddResource(url, doc); err != nil {
+			panic(fmt.Errorf("widget type schema %v: %w", name, err))
+		}
+
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			panic(fmt.Errorf("widget type schema %v: %w", name, err))
+		}
+
+		widgetType := WidgetType(strings.TrimSuffix(name, ".schema.json"))
+		newConfig, ok := newWidgetConfig[widgetType]
+		if !ok {
+			panic(fmt.Errorf("widget type %v has a schema but no typed config", widgetType))
+		}
+		if err := matchTypedConfig(doc, newConfig()); err != nil {
+			panic(fmt.Errorf("widget type %v: %w", widgetType, err))
+		}
+
+		defaults, err := json.Marshal(widgetTypeDefaults[widgetType])
+		if err != nil {
+			panic(err)
+		}
+
+		defaultDoc, _ := jsonschema.UnmarshalJSON(strings.NewReader(string(defaults)))
+		if err := schema.Validate(defaultDoc); err != nil {
+			panic(fmt.Errorf("widget type %v default config: %w", widgetType, err))
+		}
+
+		result[widgetType] = widgetTypeEntry{WidgetTypeInfo{widgetType, raw, defaults}, schema}
+	}
+
+	for widgetType := range newWidgetConfig {
+		if _, ok := result[widgetType]; !ok {
+			panic(fmt.Errorf("widget type %v has a typed config but no schema", widgetType))
+		}
+	}
+
+	return result
+}
+
+// DefaultConfig is the config a new widget of type t starts with, "" for an unknown type.
+func (t WidgetType) DefaultConfig() string {
+	return string(widgetTypes[t].info.Default)
+}
+
+// matchTypedConfig checks that the top-level properties of schema are exactly the JSON fields of config, so the
+// two cannot drift apart unnoticed.
+func matchTypedConfig(schema any, config any) error {
+	properties, _ := schema.(map[string]any)["properties"].(map[string]any)
+
+	fields := map[string]bool{}
+	configType := reflect.TypeOf(config).Elem()
+	for i := 0; i < configType.NumField(); i++ {
+		name, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+		fields[name] = true
+
+		if _, ok := properties[name]; !ok {
+			return fmt.Errorf("typed config field %v is not in the schema", name)
+		}
+	}
+
+	for name := range properties {
+		if !fields[name] {
+			return fmt.Errorf("schema property %v is not in the typed config", name)
+		}
+	}
+
+	return nil
+}
+
+// WidgetTypes lists the registered types ordered by name.
+func WidgetTypes() []WidgetTypeInfo {
+	result := make([]WidgetTypeInfo, 0, len(widgetTypes))
+	for _, entry := range widgetTypes {
+		result = append(result, entry.info)
+	}
+	slices.SortFunc(result, func(a, b WidgetTypeInfo) int { return strings.Compare(string(a.Type), string(b.Type)) })
+
+	return result
+}
+
+// ValidateConfig checks config against the type's schema and returns a *ConfigError listing each violation. A
+// config the schema accepts must also decode into the typed config, anything else means they went out of sync.
+func (t WidgetType) ValidateConfig(config string) error {
+	entry, ok := widgetTypes[t]
+	if !ok {
+		return ErrUnknownWidgetType
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(config))
+	if err != nil {
+		return &ConfigError{[]FieldError{{Field: "", Message: "config is not valid JSON"}}}
+	}
+
+	err = entry.schema.Validate(doc)
+
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		result := &ConfigError{}
+		collectFieldErrors(validationErr, result)
+		return result
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(config), newWidgetConfig[t]()); err != nil {
+		return fmt.Errorf("widget type %v typed config out of sync with its schema: %w", t, err)
+	}
+	return nil
+}
+
+// collectFieldErrors flattens the cause tree into its leaves, which carry the actual violations.
+func collectFieldErrors(err *jsonschema.ValidationError, result *ConfigError) {
+	if len(err.Causes) == 0 {
+		field := ""
+		if len(err.InstanceLocation) > 0 {
+			field = "/" + strings.Join(err.InstanceLocation, "/")
+		}
+		result.Errors = append(result.Errors, FieldError{field, err.ErrorKind.LocalizedString(schemaPrinter)})
+		return
+	}
+
+	for _, cause := range err.Causes {
+		collectFieldErrors(cause, result)
+	}
+}
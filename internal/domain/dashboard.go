@@ -4,4 +4,9 @@ type Dashboard struct {
 	Id       int
 	Name     string
 	ParentId *int
+	Version  int
+	Grid     GridSettings
+
+	// ProvisionKey is set on dashboards applied from definition files, the API treats them as read-only.
+	ProvisionKey *string
 }
@@ -0,0 +1,196 @@
+package document_models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	models "nsi/internal/domain"
+	"strings"
+)
+
+// Version is the document format written by export. Import refuses other versions, a format change bumps it and
+// teaches import to read the older ones.
+const Version = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported document version")
+	ErrImportConflict     = errors.New("import conflicts with existing dashboards")
+)
+
+// Document is a dashboard, its widgets and optionally its subtree, portable between instances. Ids are those of the
+// exporting instance, import assigns new ones and reports the mapping.
+type Document struct {
+	Version   int       `json:"version"`
+	Dashboard Dashboard `json:"dashboard"`
+}
+
+type Dashboard struct {
+	Id       int         `json:"id"`
+	Name     string      `json:"name"`
+	Grid     Grid        `json:"grid"`
+	Widgets  []Widget    `json:"widgets"`
+	Children []Dashboard `json:"children,omitempty"`
+}
+
+type Grid struct {
+	Columns   int  `json:"columns"`
+	RowHeight int  `json:"rowHeight"`
+	Snap      bool `json:"snap"`
+	Collision bool `json:"collision"`
+}
+
+type Widget struct {
+	Id     int               `json:"id"`
+	Name   string            `json:"name"`
+	Type   models.WidgetType `json:"type"`
+	Config json.RawMessage   `json:"config"`
+	Layout Layout            `json:"layout"`
+}
+
+type Layout struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	W      float64 `json:"w"`
+	H      float64 `json:"h"`
+	Z      int     `json:"z"`
+	Locked bool    `json:"locked"`
+}
+
+// DashboardOf is the dashboard without widgets and children, which the caller appends.
+func DashboardOf(dashboard models.Dashboard) Dashboard {
+	return Dashboard{Id: dashboard.Id, Name: dashboard.Name, Grid: GridOf(dashboard.Grid), Widgets: []Widget{}}
+}
+
+// WidgetOf keeps a config that is not valid JSON as a JSON string rather than failing the whole document.
+func WidgetOf(widget models.Widget) Widget {
+	config := json.RawMessage(widget.Config)
+	if !json.Valid(config) {
+		config, _ = json.Marshal(widget.Config)
+	}
+
+	return Widget{Id: widget.Id, Name: widget.Name, Type: widget.WidgetType, Config: config, Layout: LayoutOf(widget.Layout)}
+}
+
+func GridOf(grid models.GridSettings) Grid {
+	return Grid{grid.Columns, grid.RowHeight, grid.Snap, grid.Collision}
+}
+
+func (g Grid) Settings() models.GridSettings {
+	return models.GridSettings{Columns: g.Columns, RowHeight: g.RowHeight, Snap: g.Snap, Collision: g.Collision}
+}
+
+func LayoutOf(layout models.WidgetLayout) Layout {
+	return Layout{layout.X, layout.Y, layout.W, layout.H, layout.Z, layout.Locked}
+}
+
+func (l Layout) WidgetLayout() models.WidgetLayout {
+	return models.WidgetLayout{X: l.X, Y: l.Y, W: l.W, H: l.H, Z: l.Z, Locked: l.Locked}
+}
+
+// Problem points at what is wrong in a document, Path is a JSON pointer into it.
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// InvalidError lists everything that keeps a document from being imported.
+type InvalidError struct {
+	Problems []Problem `json:"problems"`
+}
+
+func (e *InvalidError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, item := range e.Problems {
+		messages = append(messages, fmt.Sprintf("%v: %v", item.Path, item.Message))
+	}
+	return "invalid document: " + strings.Join(messages, "; ")
+}
+
+// Report is the outcome of an import, the maps go from ids in the document to the ids created for them.
+type Report struct {
+	Dashboards map[int]int `json:"dashboards"`
+	Widgets    map[int]int `json:"widgets"`
+	Conflicts  []Problem   `json:"conflicts,omitempty"`
+}
+
+// Validate checks the whole document the way creating each dashboard and widget would, so an import either
+// fails up front with every problem or goes through.
+func (d Document) Validate() error {
+	if d.Version != Version {
+		return fmt.Errorf("%w: %v", ErrUnsupportedVersion, d.Version)
+	}
+
+	result := &InvalidError{}
+	dashboards, widgets := map[int]bool{}, map[int]bool{}
+	d.Dashboard.validate("/dashboard", dashboards, widgets, result)
+
+	if len(result.Problems) > 0 {
+		return result
+	}
+	return nil
+}
+
+func (d Dashboard) validate(path string, dashboards, widgets map[int]bool, result *InvalidError) {
+	report := func(at string, message string) {
+		result.Problems = append(result.Problems, Problem{path + at, message})
+	}
+
+	if dashboards[d.Id] {
+		report("/id", "duplicate dashboard id")
+	}
+	dashboards[d.Id] = true
+
+	if d.Name == "" {
+		report("/name", "name is required")
+	}
+
+	grid := d.Grid.Settings()
+	gridErr := grid.Validate()
+	if gridErr != nil {
+		report("/grid", gridErr.Error())
+	}
+
+	placed := make([]models.WidgetLayout, 0, len(d.Widgets))
+	for i, widget := range d.Widgets {
+		at := fmt.Sprintf("/widgets/%d", i)
+
+		if widgets[widget.Id] {
+			report(at+"/id", "duplicate widget id")
+		}
+		widgets[widget.Id] = true
+
+		err := widget.Type.ValidateConfig(string(widget.Config))
+		var configErr *models.ConfigError
+		if errors.As(err, &configErr) {
+			for _, field := range configErr.Errors {
+				report(at+"/config"+field.Field, field.Message)
+			}
+		} else if err != nil {
+			report(at+"/type", err.Error())
+		}
+
+		if gridErr != nil {
+			continue
+		}
+
+		layout, err := grid.Fit(widget.Layout.WidgetLayout())
+		if err != nil {
+			report(at+"/layout", err.Error())
+			continue
+		}
+
+		if grid.Collision {
+			for _, other := range placed {
+				if layout.Overlaps(other) {
+					report(at+"/layout", models.ErrLayoutOverlap.Error())
+					break
+				}
+			}
+		}
+		placed = append(placed, layout)
+	}
+
+	for i, child := range d.Children {
+		child.validate(fmt.Sprintf("%v/children/%d", path, i), dashboards, widgets, result)
+	}
+}
@@ -0,0 +1,17 @@
+package models
+
+import "errors"
+
+var (
+	// ErrVersionConflict is returned when a write carries a version (If-Match) that is no longer current.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrInvalidPatch is returned for a config patch that is malformed or cannot be applied, including a failed "test".
+	ErrInvalidPatch = errors.New("invalid patch")
+
+	ErrInvalidLayout = errors.New("layout outside of the dashboard grid")
+	ErrLayoutOverlap = errors.New("layout overlaps another widget")
+	ErrWidgetLocked  = errors.New("widget is locked")
+	ErrProvisioned   = errors.New("dashboard is provisioned and read-only")
+
+	ErrRevisionNotFound = errors.New("revision not found")
+)
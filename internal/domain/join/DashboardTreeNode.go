@@ -0,0 +1,12 @@
+package join_models
+
+import models "nsi/internal/domain"
+
+// Placeholder nodes are ancestors the user has no right on, kept only to connect visible dashboards to the root.
+// They carry nothing but Id and ParentId.
+type DashboardTreeNode struct {
+	models.Dashboard
+	AccessType  *models.GrantType
+	Placeholder bool
+	Children    []*DashboardTreeNode
+}
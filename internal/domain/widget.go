@@ -1,9 +1,29 @@
 package models
 
+import (
+	"encoding/json"
+	"reflect"
+)
+
 type WidgetType string
 
 const (
-	Square WidgetType = "square"
+	Square       WidgetType = "square"
+	LineChart    WidgetType = "line_chart"
+	BarChart     WidgetType = "bar_chart"
+	PieChart     WidgetType = "pie_chart"
+	Table        WidgetType = "table"
+	MarkdownText WidgetType = "markdown_text"
+	SingleStat   WidgetType = "single_stat"
+	Image        WidgetType = "image"
+)
+
+// ConfigPatch is the format of a partial widget config update.
+type ConfigPatch string
+
+const (
+	MergePatch ConfigPatch = "merge-patch" // RFC 7396
+	JSONPatch  ConfigPatch = "json-patch"  // RFC 6902
 )
 
 type Widget struct {
@@ -12,4 +32,32 @@ type Widget struct {
 	DashboardId int
 	WidgetType  WidgetType
 	Config      string
+	Version     int
+	Layout      WidgetLayout
+
+	ProvisionKey *string // key within a provisioned dashboard
+}
+
+// Differences names the fields of w that do not match other, ids, versions and keys aside. Configs are compared
+// as JSON values, a stored one comes back from jsonb with its keys reordered.
+func (w Widget) Differences(other Widget) []string {
+	var result []string
+	if w.Name != other.Name {
+		result = append(result, "name")
+	}
+	if w.WidgetType != other.WidgetType {
+		result = append(result, "type")
+	}
+
+	var config, otherConfig any
+	json.Unmarshal([]byte(w.Config), &config)
+	json.Unmarshal([]byte(other.Config), &otherConfig)
+	if !reflect.DeepEqual(config, otherConfig) {
+		result = append(result, "config")
+	}
+
+	if w.Layout != other.Layout {
+		result = append(result, "layout")
+	}
+	return result
 }
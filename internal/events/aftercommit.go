@@ -0,0 +1,33 @@
+package events
+
+import "context"
+
+type Committer interface {
+	AfterCommit(ctx context.Context, fn func())
+}
+
+// AfterCommit is a Publisher holding events back until the transaction of the publishing context commits, for
+// consumers outside the database such as the realtime hub. Events of a rolled back transaction are dropped.
+type AfterCommit struct {
+	publisher Publisher
+	committer Committer
+}
+
+func NewAfterCommit(publisher Publisher, committer Committer) *AfterCommit {
+	return &AfterCommit{publisher, committer}
+}
+
+func (a *AfterCommit) Publish(ctx context.Context, topic string, payload []byte) error {
+	// the request may be over by the time the transaction commits
+	ctx = context.WithoutCancel(ctx)
+	a.committer.AfterCommit(ctx, func() {
+		// the change is committed, a publisher failing now has nobody left to report to
+		a.publisher.Publish(ctx, topic, payload)
+	})
+
+	return nil
+}
+
+func (a *AfterCommit) Close() error {
+	return a.publisher.Close()
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Emitter is what services use to announce changes.
+type Emitter interface {
+	Emit(ctx context.Context, eventType Type, actor int, dashboardId *int, payload any, users ...int) error
+}
+
+type Audience interface {
+	GetDashboardAudience(ctx context.Context, dashboardId int) ([]int, error)
+	GetWidgetAudience(ctx context.Context, dashboardId int, widgetIds []int) ([]int, error)
+}
+
+// widgetScoped is implemented by payloads about particular widgets. A nil result means the payload is not about
+// widgets after all, as for a dashboard right.
+type widgetScoped interface {
+	widgets() []int
+}
+
+// Dispatcher fans an event out to the topic of every user who can read the affected dashboard.
+type Dispatcher struct {
+	publisher Publisher
+	audience  Audience
+}
+
+func NewDispatcher(publisher Publisher, audience Audience) *Dispatcher {
+	return &Dispatcher{publisher, audience}
+}
+
+// Emit publishes one envelope to the actor, the listed users and, when dashboardId is set, everyone
+// with a right on that dashboard, its ancestors or its widgets. Events about widgets only reach those who can see
+// the widgets: their grantees and the dashboard admins. Anonymous actors (id 0) are skipped.
+func (d *Dispatcher) Emit(ctx context.Context, eventType Type, actor int, dashboardId *int, payload any, users ...int) error {
+	envelope, err := New(eventType, actor, dashboardId, payload)
+	if err != nil {
+		return err
+	}
+
+	message, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	recipients := append([]int{actor}, users...)
+	if dashboardId != nil {
+		var audience []int
+		if scoped, ok := payload.(widgetScoped); ok && scoped.widgets() != nil {
+			audience, err = d.audience.GetWidgetAudience(ctx, *dashboardId, scoped.widgets())
+		} else {
+			audience, err = d.audience.GetDashboardAudience(ctx, *dashboardId)
+		}
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, audience...)
+	}
+
+	sent := make(map[int]bool, len(recipients))
+	for _, userId := range recipients {
+		if userId == 0 || sent[userId] {
+			continue
+		}
+		sent[userId] = true
+
+		if err := d.publisher.Publish(ctx, UserTopic(userId), message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
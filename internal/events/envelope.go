@@ -0,0 +1,68 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the envelope contract, bump it on breaking changes to the envelope or any payload.
+const Version = 1
+
+type Type string
+
+// Envelope is the message published on nsi.* topics, Payload holds one of the structs from payloads.go.
+type Envelope struct {
+	Type        Type            `json:"type"`
+	Version     int             `json:"version"`
+	Id          string          `json:"id"`
+	Actor       int             `json:"actor"`
+	DashboardId *int            `json:"dashboardId,omitempty"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+func New(eventType Type, actor int, dashboardId *int, payload any) (*Envelope, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Type:        eventType,
+		Version:     Version,
+		Id:          hex.EncodeToString(id),
+		Actor:       actor,
+		DashboardId: dashboardId,
+		Timestamp:   time.Now().UTC(),
+		Payload:     raw,
+	}, nil
+}
+
+func UserTopic(userId int) string {
+	return fmt.Sprintf("nsi.%v", userId)
+}
+
+// ParseUserTopic is the inverse of UserTopic.
+func ParseUserTopic(topic string) (int, bool) {
+	raw, ok := strings.CutPrefix(topic, "nsi.")
+	if !ok {
+		return 0, false
+	}
+
+	userId, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, false
+	}
+
+	return userId, true
+}
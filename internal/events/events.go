@@ -0,0 +1,9 @@
+package events
+
+import "context"
+
+// Publisher delivers a serialized event to a topic, implementations must be safe for concurrent use.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Close() error
+}
@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Memory keeps published messages in process, meant for tests and local runs.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(ctx context.Context, topic string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, Message{Topic: topic, Payload: append([]byte(nil), payload...)})
+	return nil
+}
+
+// Messages returns a copy of everything published so far.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"context"
+	"errors"
+)
+
+// Multi hands every event to each of its publishers, a failing one does not stop the others.
+type Multi []Publisher
+
+func NewMulti(publishers ...Publisher) Multi {
+	return Multi(publishers)
+}
+
+func (m Multi) Publish(ctx context.Context, topic string, payload []byte) error {
+	var errs []error
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, topic, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m Multi) Close() error {
+	var errs []error
+	for _, publisher := range m {
+		if err := publisher.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
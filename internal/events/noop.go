@@ -0,0 +1,14 @@
+package events
+
+import "context"
+
+// Noop drops every event, used when the deployment runs without Kafka.
+type Noop struct{}
+
+func (Noop) Publish(ctx context.Context, topic string, payload []byte) error {
+	return nil
+}
+
+func (Noop) Close() error {
+	return nil
+}
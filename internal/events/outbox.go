@@ -0,0 +1,25 @@
+package events
+
+import "context"
+
+type OutboxStore interface {
+	EnqueueEvent(ctx context.Context, topic string, payload []byte) error
+}
+
+// Outbox is a Publisher writing events to the outbox table instead of the broker. Publishing with a
+// transactional context stores the event atomically with the change, the relay delivers it afterwards.
+type Outbox struct {
+	store OutboxStore
+}
+
+func NewOutbox(store OutboxStore) *Outbox {
+	return &Outbox{store}
+}
+
+func (o *Outbox) Publish(ctx context.Context, topic string, payload []byte) error {
+	return o.store.EnqueueEvent(ctx, topic, payload)
+}
+
+func (o *Outbox) Close() error {
+	return nil
+}
@@ -0,0 +1,172 @@
+package events
+
+import (
+	"encoding/json"
+	models "nsi/internal/domain"
+)
+
+const (
+	DashboardCreate  Type = "dashboard_create"
+	DashboardUpdate  Type = "dashboard_update"
+	DashboardDelete  Type = "dashboard_delete"
+	DashboardRestore Type = "dashboard_restore"
+
+	WidgetCreate       Type = "widget_create"
+	WidgetUpdateConfig Type = "widget_update_config"
+	WidgetPatchConfig  Type = "widget_patch_config"
+	WidgetUpdatePos    Type = "widget_update_pos"
+	WidgetDelete       Type = "widget_delete"
+
+	LayoutChanged Type = "layout_changed"
+
+	RightsCreate Type = "rights_create"
+	RightsUpdate Type = "rights_update"
+	RightsDelete Type = "rights_delete"
+
+	PresenceJoin      Type = "presence_join"
+	PresenceLeave     Type = "presence_leave"
+	PresenceHeartbeat Type = "presence_heartbeat"
+	PresencePointer   Type = "presence_pointer"
+	PresenceSelection Type = "presence_selection"
+)
+
+type DashboardPayload struct {
+	DashboardId int    `json:"dashboardId"`
+	Name        string `json:"name"`
+	ParentId    *int   `json:"parentId"`
+	Grid        Grid   `json:"grid"`
+}
+
+type Grid struct {
+	Columns   int  `json:"columns"`
+	RowHeight int  `json:"rowHeight"`
+	Snap      bool `json:"snap"`
+	Collision bool `json:"collision"`
+}
+
+func GridOf(grid models.GridSettings) Grid {
+	return Grid{grid.Columns, grid.RowHeight, grid.Snap, grid.Collision}
+}
+
+type Layout struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	W      float64 `json:"w"`
+	H      float64 `json:"h"`
+	Z      int     `json:"z"`
+	Locked bool    `json:"locked"`
+}
+
+func LayoutOf(layout models.WidgetLayout) Layout {
+	return Layout{layout.X, layout.Y, layout.W, layout.H, layout.Z, layout.Locked}
+}
+
+type DashboardDeletePayload struct {
+	DashboardId int `json:"dashboardId"`
+}
+
+// DashboardRestorePayload follows the widget events of a restore, Version is the revision that was restored and
+// Revision the one recording the restore.
+type DashboardRestorePayload struct {
+	DashboardId int `json:"dashboardId"`
+	Version     int `json:"version"`
+	Revision    int `json:"revision"`
+}
+
+type WidgetCreatePayload struct {
+	WidgetId    int               `json:"widgetId"`
+	Name        string            `json:"name"`
+	DashboardId int               `json:"dashboardId"`
+	WidgetType  models.WidgetType `json:"type"`
+	Config      json.RawMessage   `json:"config"`
+	Layout      Layout            `json:"layout"`
+}
+
+func (p WidgetCreatePayload) widgets() []int {
+	return []int{p.WidgetId}
+}
+
+func WidgetCreateOf(widget models.Widget) WidgetCreatePayload {
+	return WidgetCreatePayload{widget.Id, widget.Name, widget.DashboardId, widget.WidgetType, json.RawMessage(widget.Config), LayoutOf(widget.Layout)}
+}
+
+type WidgetConfigPayload struct {
+	WidgetId int             `json:"widgetId"`
+	Config   json.RawMessage `json:"config"`
+	Version  int             `json:"version"`
+}
+
+func (p WidgetConfigPayload) widgets() []int {
+	return []int{p.WidgetId}
+}
+
+// WidgetPatchPayload carries the patch as sent by the client, not the resulting config, so consumers can
+// apply edits to other keys made in between without a conflict.
+type WidgetPatchPayload struct {
+	WidgetId int                `json:"widgetId"`
+	Format   models.ConfigPatch `json:"format"`
+	Patch    json.RawMessage    `json:"patch"`
+	Version  int                `json:"version"`
+}
+
+func (p WidgetPatchPayload) widgets() []int {
+	return []int{p.WidgetId}
+}
+
+// WidgetLayoutPayload goes out as widget_update_pos, x and y stay at the top level for older consumers.
+type WidgetLayoutPayload struct {
+	WidgetId int `json:"widgetId"`
+	Layout
+	Version int `json:"version"`
+}
+
+func (p WidgetLayoutPayload) widgets() []int {
+	return []int{p.WidgetId}
+}
+
+// LayoutChangedPayload carries every widget moved by one bulk layout update.
+type LayoutChangedPayload struct {
+	DashboardId int                   `json:"dashboardId"`
+	Widgets     []WidgetLayoutPayload `json:"widgets"`
+}
+
+func (p LayoutChangedPayload) widgets() []int {
+	result := make([]int, 0, len(p.Widgets))
+	for _, widget := range p.Widgets {
+		result = append(result, widget.WidgetId)
+	}
+	return result
+}
+
+type WidgetDeletePayload struct {
+	WidgetId int `json:"widgetId"`
+}
+
+func (p WidgetDeletePayload) widgets() []int {
+	return []int{p.WidgetId}
+}
+
+type RightsPayload struct {
+	RightId     int              `json:"rightId"`
+	UserId      *int             `json:"userId,omitempty"`
+	GroupId     *int             `json:"groupId,omitempty"`
+	DashboardId *int             `json:"dashboardId,omitempty"`
+	WidgetId    *int             `json:"widgetId,omitempty"`
+	Grant       models.GrantType `json:"grant,omitempty"`
+}
+
+func (p RightsPayload) widgets() []int {
+	if p.WidgetId == nil {
+		return nil
+	}
+	return []int{*p.WidgetId}
+}
+
+// PresencePayload is sent straight to the other connections on the dashboard, presence never goes through the outbox.
+type PresencePayload struct {
+	SessionId string               `json:"sessionId"`
+	UserId    int                  `json:"userId"`
+	State     models.PresenceState `json:"state,omitempty"`
+	Pointer   *models.Pointer      `json:"pointer,omitempty"`
+	Selection []int                `json:"selection,omitempty"`
+}
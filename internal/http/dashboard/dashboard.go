@@ -3,50 +3,93 @@ package dashboardController
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	grpcHandler "nsi/internal/auth"
 	models "nsi/internal/domain"
+	document_models "nsi/internal/domain/document"
 	join_models "nsi/internal/domain/join"
+	"nsi/internal/http/etag"
 	"strconv"
 	"time"
 )
 
+const maxDocumentSize = 10 << 20
+
 type dashboardHelper struct {
 	log      *slog.Logger
 	timeout  time.Duration
 	handlers DashboardHandlers
 	rights   RightHandler
+	realtime Realtime
 }
 
 type DashboardHandlers interface {
 	Create(ctx context.Context, name string, parentId *int, ownerId int, rightService RightHandler) (id int, err error)
-	Delete(ctx context.Context, id int) error
-	Update(ctx context.Context, id int, dashboard models.Dashboard) error
+	Delete(ctx context.Context, id int, actorId int) error
+	Update(ctx context.Context, id int, dashboard models.Dashboard, actorId int) (*models.Dashboard, error)
+	Clone(ctx context.Context, id int, name string, parentId *int, children bool, rights bool, ownerId int, rightService RightHandler) (cloneId int, err error)
+	Export(ctx context.Context, id int, children bool, viewerId *int) (*document_models.Document, error)
+	Import(ctx context.Context, document document_models.Document, parentId *int, rename bool, ownerId int, rightService RightHandler) (*document_models.Report, error)
 
 	GetDashboard(ctx context.Context, id int) (*models.Dashboard, error)
 	GetDashboardsWithAccess(ctx context.Context, userId int) ([]join_models.DashboardWithRight, error)
+	GetDashboardTree(ctx context.Context, userId int) ([]*join_models.DashboardTreeNode, error)
 }
 
 type RightHandler interface {
-	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType) (id int, err error)
+	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType, actorId int) (id int, err error)
+	Copy(ctx context.Context, fromDashboardId *int, fromWidgetId *int, toDashboardId *int, toWidgetId *int, actorId int) error
 	CheckDashboardRight(ctx context.Context, userId int, dashboardId int, rightType models.GrantType) (right *models.AccessRight, terr error)
+	CheckDashboardTokenRight(ctx context.Context, token string, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error)
 }
 
-func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, handlers DashboardHandlers, right RightHandler) {
-	helper := &dashboardHelper{logger, t, handlers, right}
+func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, handlers DashboardHandlers, right RightHandler, realtime Realtime) {
+	helper := &dashboardHelper{logger, t, handlers, right, realtime}
 
 	mux.HandleFunc("POST /dashboard/create", grpc.ValidateHandler(helper.Create()))
 	mux.HandleFunc("DELETE /dashboard/{id}", grpc.ValidateHandler(helper.Delete(models.Admin)))
-	mux.HandleFunc("GET /dashboard/{id}", grpc.ValidateHandler(helper.GetDashboard(models.ReadOnly)))
+	mux.HandleFunc("PATCH /dashboard/{id}", grpc.ValidateHandler(helper.Update(models.Update)))
+	mux.HandleFunc("GET /dashboard/{id}", grpc.ShareHandler(helper.GetDashboard(models.ReadOnly)))
+	mux.HandleFunc("POST /dashboard/{id}/clone", grpc.ValidateHandler(helper.Clone(models.ReadOnly)))
+	mux.HandleFunc("GET /dashboard/{id}/export", grpc.ShareHandler(helper.Export(models.ReadOnly)))
+	mux.HandleFunc("POST /dashboards/import", grpc.ValidateHandler(helper.Import(models.Update)))
+	mux.HandleFunc("GET /dashboard/{id}/events", grpc.StreamHandler(helper.Events(models.ReadOnly)))
+	mux.HandleFunc("GET /dashboard/{id}/presence", grpc.ValidateHandler(helper.GetPresence(models.ReadOnly)))
 	mux.HandleFunc("GET /dashboards", grpc.ValidateHandler(helper.GetDashboards()))
+	mux.HandleFunc("GET /dashboards/tree", grpc.ValidateHandler(helper.GetDashboardTree()))
 }
 
 func (d *dashboardHelper) validateRole(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType, dashboardId int) error {
+	_, err := d.viewer(ctx, r, role, dashboardId)
+	return err
+}
+
+// viewer checks role like validateRole and returns the user whose widget rights limit what the caller gets to
+// see, nil when a share token let the caller in, tokens cover every widget of the dashboard.
+func (d *dashboardHelper) viewer(ctx context.Context, r *http.Request, role models.GrantType, dashboardId int) (*int, error) {
 	userId, _ := strconv.Atoi(r.Header.Get("UserId"))
 	_, err := d.rights.CheckDashboardRight(ctx, userId, dashboardId, role)
-	return err
+	if err == nil {
+		return &userId, nil
+	}
+
+	if token := r.Header.Get(grpcHandler.ShareTokenHeader); token != "" {
+		_, err = d.rights.CheckDashboardTokenRight(ctx, token, dashboardId, role)
+	}
+	return nil, err
+}
+
+// provisioned answers a write to a provisioned dashboard with 409, it reports false when err is something else.
+func (d *dashboardHelper) provisioned(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, models.ErrProvisioned) {
+		return false
+	}
+
+	http.Error(w, err.Error(), http.StatusConflict)
+	return true
 }
 
 func (d *dashboardHelper) GetDashboards() http.HandlerFunc {
@@ -78,6 +121,35 @@ func (d *dashboardHelper) GetDashboards() http.HandlerFunc {
 	}
 }
 
+func (d *dashboardHelper) GetDashboardTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		model, err := d.handlers.GetDashboardTree(ctx, userId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		result, err := json.Marshal(model)
+
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
+	}
+}
+
 func (d *dashboardHelper) GetDashboard(role models.GrantType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
@@ -115,6 +187,8 @@ func (d *dashboardHelper) GetDashboard(role models.GrantType) http.HandlerFunc {
 			http.Error(w, "Error", http.StatusBadRequest)
 			return
 		}
+
+		w.Header().Set("ETag", etag.Format(model.Version))
 		fmt.Fprint(w, string(result))
 	}
 }
@@ -140,7 +214,12 @@ func (d *dashboardHelper) Delete(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		err = d.handlers.Delete(ctx, int(id))
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		err = d.handlers.Delete(ctx, int(id), userId)
+		if d.provisioned(w, err) {
+			return
+		}
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -169,6 +248,16 @@ func (d *dashboardHelper) Create() http.HandlerFunc {
 			return
 		}
 
+		if params.ParentId != nil {
+			err = d.validateRole(ctx, w, r, models.Update, *params.ParentId)
+			if err != nil {
+				d.log.Error(err.Error())
+
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+		}
+
 		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
 		id, err := d.handlers.Create(ctx, params.Name, params.ParentId, userId, d.rights)
 		if err != nil {
@@ -181,3 +270,311 @@ func (d *dashboardHelper) Create() http.HandlerFunc {
 		fmt.Fprint(w, id)
 	}
 }
+
+// Clone copies a dashboard the caller can read with the widgets they can see, copying its rights as well takes admin
+// on the source and placing the copy under a parent takes update there.
+func (d *dashboardHelper) Clone(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		err = d.validateRole(ctx, w, r, role, int(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		source, err := d.handlers.GetDashboard(ctx, int(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		// the copy goes next to the source unless the body says otherwise, "parentId": null puts it at the root
+		params := struct {
+			Name     string `json:"name"`
+			ParentId *int   `json:"parentId"`
+			Children bool   `json:"children"`
+			Rights   bool   `json:"rights"`
+		}{Name: source.Name + " (copy)", ParentId: source.ParentId}
+
+		if r.ContentLength != 0 {
+			err = json.NewDecoder(r.Body).Decode(&params)
+		}
+
+		if err != nil || params.Name == "" {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		if params.Rights {
+			err = d.validateRole(ctx, w, r, models.Admin, int(id))
+		}
+		if err == nil && params.ParentId != nil {
+			err = d.validateRole(ctx, w, r, models.Update, *params.ParentId)
+		}
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		cloneId, err := d.handlers.Clone(ctx, int(id), params.Name, params.ParentId, params.Children, params.Rights, userId, d.rights)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, cloneId)
+	}
+}
+
+// Export answers the dashboard as a document_models.Document, ?children=true includes its subtree.
+func (d *dashboardHelper) Export(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		children := false
+		if value := r.URL.Query().Get("children"); value != "" {
+			if children, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, "Invalid data", http.StatusBadRequest)
+				return
+			}
+		}
+
+		viewerId, err := d.viewer(ctx, r, role, int(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		document, err := d.handlers.Export(ctx, int(id), children, viewerId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		result, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dashboard-%d.json"`, id))
+		fmt.Fprint(w, string(result))
+	}
+}
+
+// Import creates the dashboards of an exported document under ?parentId= (the root when missing) and answers the
+// id mapping. An invalid document gets 422 with every problem, a name clash 409 unless ?onConflict=rename.
+func (d *dashboardHelper) Import(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		query := r.URL.Query()
+
+		var parentId *int
+		if value := query.Get("parentId"); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "Invalid data", http.StatusBadRequest)
+				return
+			}
+			parentId = &id
+		}
+
+		rename := false
+		switch query.Get("onConflict") {
+		case "", "fail":
+		case "rename":
+			rename = true
+		default:
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		document := document_models.Document{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDocumentSize)).Decode(&document)
+
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		if parentId != nil {
+			err = d.validateRole(ctx, w, r, role, *parentId)
+			if err != nil {
+				d.log.Error(err.Error())
+
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		report, err := d.handlers.Import(ctx, document, parentId, rename, userId, d.rights)
+
+		var invalid *document_models.InvalidError
+		switch {
+		case errors.Is(err, document_models.ErrUnsupportedVersion):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.As(err, &invalid):
+			d.writeJSON(w, http.StatusUnprocessableEntity, invalid)
+			return
+		case errors.Is(err, document_models.ErrImportConflict):
+			d.writeJSON(w, http.StatusConflict, report)
+			return
+		case err != nil:
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		d.writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func (d *dashboardHelper) writeJSON(w http.ResponseWriter, status int, value any) {
+	result, err := json.Marshal(value)
+	if err != nil {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(result)
+}
+
+func (d *dashboardHelper) Update(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		err = d.validateRole(ctx, w, r, role, int(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		current, err := d.handlers.GetDashboard(ctx, int(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		// fields missing from the body keep their current values, "parentId": null moves the dashboard to the root
+		params := struct {
+			Name     string              `json:"name"`
+			ParentId *int                `json:"parentId"`
+			Grid     models.GridSettings `json:"grid"`
+		}{Name: current.Name, ParentId: current.ParentId, Grid: current.Grid}
+
+		err = json.NewDecoder(r.Body).Decode(&params)
+
+		if err != nil || params.Name == "" {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		// If-Match is optional here, without it the last write wins
+		version := 0
+		if header := r.Header.Get("If-Match"); header != "" {
+			var ok bool
+			if version, ok = etag.Parse(header); !ok {
+				http.Error(w, "Invalid data", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if params.ParentId != nil && (current.ParentId == nil || *current.ParentId != *params.ParentId) {
+			err = d.validateRole(ctx, w, r, role, *params.ParentId)
+			if err != nil {
+				d.log.Error(err.Error())
+
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		model := models.Dashboard{Id: int(id), Name: params.Name, ParentId: params.ParentId, Version: version, Grid: params.Grid}
+		updated, err := d.handlers.Update(ctx, int(id), model, userId)
+		if d.provisioned(w, err) {
+			return
+		}
+		if errors.Is(err, models.ErrVersionConflict) {
+			etag.PreconditionFailed(w, updated.Version, updated)
+			return
+		}
+		if errors.Is(err, models.ErrInvalidLayout) {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, models.ErrLayoutOverlap) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("ETag", etag.Format(updated.Version))
+		fmt.Fprint(w, id)
+	}
+}
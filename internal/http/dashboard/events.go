@@ -0,0 +1,158 @@
+package dashboardController
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	models "nsi/internal/domain"
+	"nsi/internal/events"
+	"nsi/internal/realtime"
+	"strconv"
+	"time"
+)
+
+const heartbeatPeriod = 15 * time.Second
+
+// streamedEvents are the envelope types forwarded on GET /dashboard/{id}/events.
+var streamedEvents = map[events.Type]bool{
+	events.WidgetCreate:       true,
+	events.WidgetUpdateConfig: true,
+	events.WidgetPatchConfig:  true,
+	events.WidgetUpdatePos:    true,
+	events.WidgetDelete:       true,
+	events.LayoutChanged:      true,
+	events.RightsCreate:       true,
+	events.RightsUpdate:       true,
+	events.RightsDelete:       true,
+}
+
+type Realtime interface {
+	Subscribe(userId int, dashboardId int, lastId string) (client *realtime.Client, backlog [][]byte, resumed bool)
+	Unregister(client *realtime.Client)
+	Presence(dashboardId int) []models.PresenceMember
+}
+
+// Events streams the dashboard's widget and rights events as Server-Sent Events. Each event id is the envelope id,
+// a reconnect with Last-Event-ID replays what was missed, or gets a "reset" event when that is no longer possible.
+func (d *dashboardHelper) Events(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		_, err = d.rights.CheckDashboardRight(ctx, userId, id, role)
+		cancel()
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		lastId := r.Header.Get("Last-Event-ID")
+		if lastId == "" {
+			lastId = r.URL.Query().Get("lastEventId")
+		}
+
+		client, backlog, resumed := d.realtime.Subscribe(userId, id, lastId)
+		defer d.realtime.Unregister(client)
+
+		controller := http.NewResponseController(w)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if lastId != "" && !resumed {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, message := range backlog {
+			d.writeEvent(w, message)
+		}
+		if err := controller.Flush(); err != nil {
+			d.log.Error(err.Error())
+			return
+		}
+
+		ticker := time.NewTicker(heartbeatPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case message, ok := <-client.Messages():
+				if !ok {
+					return
+				}
+				d.writeEvent(w, message)
+			case <-ticker.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *dashboardHelper) writeEvent(w http.ResponseWriter, message []byte) {
+	envelope := struct {
+		Id   string      `json:"id"`
+		Type events.Type `json:"type"`
+	}{}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		d.log.Error(err.Error())
+		return
+	}
+	if !streamedEvents[envelope.Type] {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", envelope.Id, envelope.Type, message)
+}
+
+// GetPresence lists the connections that joined the dashboard over /ws.
+func (d *dashboardHelper) GetPresence(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		err = d.validateRole(ctx, w, r, role, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		result, err := json.Marshal(d.realtime.Presence(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
+	}
+}
@@ -0,0 +1,49 @@
+package etag
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Format renders a row version as a strong ETag.
+func Format(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// Parse reads an If-Match header produced from Format. "*" matches any version and yields 0, which storage treats
+// as unconditional. ok is false for a missing or malformed header.
+func Parse(header string) (version int, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, true
+	}
+
+	raw, err := strconv.Unquote(strings.TrimPrefix(header, "W/"))
+	if err != nil {
+		return 0, false
+	}
+
+	version, err = strconv.Atoi(raw)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// PreconditionFailed answers a stale write with 412 and the current state of the resource, so the client can
+// merge its change and retry with the returned ETag.
+func PreconditionFailed(w http.ResponseWriter, version int, current any) {
+	result, err := json.Marshal(current)
+	if err != nil {
+		http.Error(w, "Error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", Format(version))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	w.Write(result)
+}
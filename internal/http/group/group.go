@@ -0,0 +1,305 @@
+package groupController
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	grpcHandler "nsi/internal/auth"
+	models "nsi/internal/domain"
+	"strconv"
+	"time"
+)
+
+type groupHelper struct {
+	log      *slog.Logger
+	timeout  time.Duration
+	handlers GroupHandlers
+}
+
+type GroupHandlers interface {
+	Create(ctx context.Context, name string, ownerId int) (id int, err error)
+	Delete(ctx context.Context, id int) error
+	Update(ctx context.Context, id int, name string) error
+
+	GetGroups(ctx context.Context, userId int) ([]models.UserGroup, error)
+	GetMembers(ctx context.Context, id int) ([]int, error)
+	CheckGroup(ctx context.Context, userId int, id int, ownerOnly bool) (*models.UserGroup, error)
+
+	AddMember(ctx context.Context, id int, userId int) error
+	RemoveMember(ctx context.Context, id int, userId int) error
+}
+
+func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, handlers GroupHandlers) {
+	helper := &groupHelper{logger, t, handlers}
+
+	mux.HandleFunc("POST /groups/create", grpc.ValidateHandler(helper.Create()))
+	mux.HandleFunc("GET /groups", grpc.ValidateHandler(helper.GetGroups()))
+	mux.HandleFunc("GET /groups/{id}", grpc.ValidateHandler(helper.GetGroup()))
+	mux.HandleFunc("PATCH /groups/{id}", grpc.ValidateHandler(helper.Update()))
+	mux.HandleFunc("DELETE /groups/{id}", grpc.ValidateHandler(helper.Delete()))
+
+	mux.HandleFunc("POST /groups/{id}/members", grpc.ValidateHandler(helper.AddMember()))
+	mux.HandleFunc("DELETE /groups/{id}/members/{userId}", grpc.ValidateHandler(helper.RemoveMember()))
+}
+
+func (d *groupHelper) validateGroup(ctx context.Context, r *http.Request, groupId int, ownerOnly bool) (*models.UserGroup, error) {
+	userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+	return d.handlers.CheckGroup(ctx, userId, groupId, ownerOnly)
+}
+
+func (d *groupHelper) GetGroups() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		model, err := d.handlers.GetGroups(ctx, userId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		result, err := json.Marshal(model)
+
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
+	}
+}
+
+func (d *groupHelper) GetGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		group, err := d.validateGroup(ctx, r, id, false)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		members, err := d.handlers.GetMembers(ctx, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		result, err := json.Marshal(struct {
+			models.UserGroup
+			Members []int
+		}{*group, members})
+
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
+	}
+}
+
+func (d *groupHelper) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		params := struct {
+			Name string `json:"name"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&params)
+
+		if err != nil || params.Name == "" {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		id, err := d.handlers.Create(ctx, params.Name, userId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, id)
+	}
+}
+
+func (d *groupHelper) Update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err1 := strconv.Atoi(r.PathValue("id"))
+
+		params := struct {
+			Name string `json:"name"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&params)
+
+		if err != nil || err1 != nil || params.Name == "" {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		_, err = d.validateGroup(ctx, r, id, true)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		err = d.handlers.Update(ctx, id, params.Name)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, id)
+	}
+}
+
+func (d *groupHelper) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		_, err = d.validateGroup(ctx, r, id, true)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		err = d.handlers.Delete(ctx, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, "Success")
+	}
+}
+
+func (d *groupHelper) AddMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err1 := strconv.Atoi(r.PathValue("id"))
+
+		params := struct {
+			UserId int `json:"userId"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&params)
+
+		if err != nil || err1 != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		_, err = d.validateGroup(ctx, r, id, true)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		err = d.handlers.AddMember(ctx, id, params.UserId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, "Success")
+	}
+}
+
+func (d *groupHelper) RemoveMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, err1 := strconv.Atoi(r.PathValue("id"))
+		memberId, err := strconv.Atoi(r.PathValue("userId"))
+
+		if err != nil || err1 != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		// members may leave on their own, everything else is up to the owner
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		_, err = d.validateGroup(ctx, r, id, memberId != userId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		err = d.handlers.RemoveMember(ctx, id, memberId)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, "Success")
+	}
+}
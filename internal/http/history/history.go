@@ -0,0 +1,217 @@
+package historyController
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	grpcHandler "nsi/internal/auth"
+	models "nsi/internal/domain"
+	document_models "nsi/internal/domain/document"
+	"strconv"
+	"time"
+)
+
+type historyHelper struct {
+	log      *slog.Logger
+	timeout  time.Duration
+	handlers HistoryHandlers
+	rights   RightHandler
+}
+
+type HistoryHandlers interface {
+	GetRevisions(ctx context.Context, dashboardId int) ([]models.Revision, error)
+	GetRevision(ctx context.Context, dashboardId int, version int, viewerId *int) (*models.Revision, error)
+	Restore(ctx context.Context, dashboardId int, version int, actorId int, rightService RightHandler) (*models.Revision, error)
+}
+
+type RightHandler interface {
+	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType, actorId int) (id int, err error)
+	CheckDashboardRight(ctx context.Context, userId int, dashboardId int, rightType models.GrantType) (right *models.AccessRight, terr error)
+	CheckDashboardTokenRight(ctx context.Context, token string, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error)
+}
+
+func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, handlers HistoryHandlers, right RightHandler) {
+	helper := &historyHelper{logger, t, handlers, right}
+
+	mux.HandleFunc("GET /dashboard/{id}/versions", grpc.ShareHandler(helper.GetRevisions(models.ReadOnly)))
+	mux.HandleFunc("GET /dashboard/{id}/versions/{v}", grpc.ShareHandler(helper.GetRevision(models.ReadOnly)))
+	mux.HandleFunc("POST /dashboard/{id}/versions/{v}/restore", grpc.ValidateHandler(helper.Restore(models.Admin)))
+}
+
+func (d *historyHelper) validateRole(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType, dashboardId int) error {
+	_, err := d.viewer(ctx, r, role, dashboardId)
+	return err
+}
+
+// viewer checks role like validateRole and returns the user whose widget rights limit which widgets of a snapshot
+// the caller gets to see. It is nil when nothing is hidden: for dashboard admins and callers with a share token,
+// tokens cover every widget of the dashboard.
+func (d *historyHelper) viewer(ctx context.Context, r *http.Request, role models.GrantType, dashboardId int) (*int, error) {
+	userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+	_, err := d.rights.CheckDashboardRight(ctx, userId, dashboardId, role)
+	if err == nil {
+		if _, err := d.rights.CheckDashboardRight(ctx, userId, dashboardId, models.Admin); err == nil {
+			return nil, nil
+		}
+		return &userId, nil
+	}
+
+	if token := r.Header.Get(grpcHandler.ShareTokenHeader); token != "" {
+		_, err = d.rights.CheckDashboardTokenRight(ctx, token, dashboardId, role)
+	}
+	return nil, err
+}
+
+// params reads the dashboard id and, with version, the revision number from the path.
+func (d *historyHelper) params(w http.ResponseWriter, r *http.Request, version bool) (int, int, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	if !version {
+		return int(id), 0, true
+	}
+
+	v, err := strconv.ParseInt(r.PathValue("v"), 10, 32)
+	if err != nil || v < 1 {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return int(id), int(v), true
+}
+
+func (d *historyHelper) GetRevisions(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, _, ok := d.params(w, r, false)
+		if !ok {
+			return
+		}
+
+		err := d.validateRole(ctx, w, r, role, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		model, err := d.handlers.GetRevisions(ctx, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		d.writeJSON(w, http.StatusOK, model)
+	}
+}
+
+func (d *historyHelper) GetRevision(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, version, ok := d.params(w, r, true)
+		if !ok {
+			return
+		}
+
+		viewerId, err := d.viewer(ctx, r, role, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		model, err := d.handlers.GetRevision(ctx, id, version, viewerId)
+		if errors.Is(err, models.ErrRevisionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		d.writeJSON(w, http.StatusOK, model)
+	}
+}
+
+// Restore answers with the revision recording the restore, 409 for a provisioned dashboard and 422 with the
+// problems when the snapshot no longer validates. It takes admin, a restore recreates, overwrites and deletes
+// widgets regardless of the caller's widget rights.
+func (d *historyHelper) Restore(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, version, ok := d.params(w, r, true)
+		if !ok {
+			return
+		}
+
+		err := d.validateRole(ctx, w, r, role, id)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		model, err := d.handlers.Restore(ctx, id, version, userId, d.rights)
+		var invalid *document_models.InvalidError
+		switch {
+		case errors.Is(err, models.ErrRevisionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, models.ErrProvisioned):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.As(err, &invalid):
+			d.writeJSON(w, http.StatusUnprocessableEntity, invalid)
+			return
+		case err != nil:
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		d.writeJSON(w, http.StatusOK, model)
+	}
+}
+
+func (d *historyHelper) writeJSON(w http.ResponseWriter, status int, value any) {
+	result, err := json.Marshal(value)
+	if err != nil {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(result)
+}
@@ -3,12 +3,12 @@ package rightsController
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	grpcHandler "nsi/internal/auth"
 	models "nsi/internal/domain"
-	producer "nsi/internal/kafka"
 	"nsi/internal/services/rights"
 	"strconv"
 	"time"
@@ -19,12 +19,16 @@ type rightsHelper struct {
 	timeout  time.Duration
 	handlers RightsHandlers
 	rights   RightHandler
+	groups   GroupChecker
 }
 
 type RightsHandlers interface {
-	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType) (id int, err error)
-	Delete(ctx context.Context, dashboardId *int, widgetdId *int, rightId int) error
-	Update(ctx context.Context, userId int, id int, grant models.GrantType) (int, error)
+	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType, actorId int) (id int, err error)
+	CreateForGroup(ctx context.Context, dashboardId *int, widgetdId *int, groupId int, grantType models.GrantType, actorId int) (id int, err error)
+	Delete(ctx context.Context, dashboardId *int, widgetdId *int, rightId int, actorId int) error
+	Update(ctx context.Context, userId int, id int, grant models.GrantType, actorId int) (int, error)
+
+	CreateShareToken(ctx context.Context, dashboardId int, grantType models.GrantType, expiresAt *time.Time) (*models.AccessRight, error)
 
 	GetRights(ctx context.Context, id int, isDasboard bool) ([]models.AccessRight, error)
 }
@@ -35,10 +39,15 @@ type RightHandler interface {
 	CheckAccessRight(ctx context.Context, userId int, accessId int, rightType models.GrantType) (right *models.AccessRight, err error)
 }
 
-func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, handlers RightsHandlers, right RightHandler) {
-	helper := &rightsHelper{logger, t, handlers, right}
+type GroupChecker interface {
+	CheckGroup(ctx context.Context, userId int, id int, ownerOnly bool) (*models.UserGroup, error)
+}
+
+func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, handlers RightsHandlers, right RightHandler, groups GroupChecker) {
+	helper := &rightsHelper{logger, t, handlers, right, groups}
 
 	mux.HandleFunc("POST /rights/create", grpc.ValidateHandler(helper.Create(models.Admin)))
+	mux.HandleFunc("POST /rights/share", grpc.ValidateHandler(helper.Share(models.Admin)))
 	mux.HandleFunc("DELETE /rights/{rightId}", grpc.ValidateHandler(helper.Delete(models.Admin)))
 	mux.HandleFunc("PATCH /rights/{rightId}", grpc.ValidateHandler(helper.Update(models.Admin)))
 
@@ -89,7 +98,8 @@ func (d *rightsHelper) Update(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		id, err := d.handlers.Update(ctx, params.UserId, rightId, params.Type)
+		actorId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		id, err := d.handlers.Update(ctx, params.UserId, rightId, params.Type, actorId)
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -98,10 +108,6 @@ func (d *rightsHelper) Update(role models.GrantType) http.HandlerFunc {
 		}
 
 		fmt.Fprint(w, id)
-
-		//todo ну это реально хреново
-		var q = fmt.Sprintf("{\"Type\":\"rights_update\", \"id\": %v, \"rightId\": %v, \"grant\":\"%v\"}", params.UserId, id, params.Type)
-		go producer.Write(fmt.Sprintf("nsi.%v", params.UserId), q)
 	}
 }
 
@@ -114,6 +120,7 @@ func (d *rightsHelper) Create(role models.GrantType) http.HandlerFunc {
 
 		params := struct {
 			UserId      int              `json:"userId"`
+			GroupId     *int             `json:"groupId"`
 			DashboardId *int             `json:"dashboardId"`
 			WidgetId    *int             `json:"widgetId"`
 			Type        models.GrantType `json:"type"`
@@ -134,6 +141,34 @@ func (d *rightsHelper) Create(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
+		actorId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		if params.GroupId != nil {
+			// granting to a group takes belonging to it, as reading its members does
+			_, err = d.groups.CheckGroup(ctx, actorId, *params.GroupId, false)
+			if err != nil {
+				d.log.Error(err.Error())
+
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+
+			id, err := d.handlers.CreateForGroup(ctx, params.DashboardId, params.WidgetId, *params.GroupId, params.Type, actorId)
+			if errors.Is(err, rights.ErrGroupNotFound) {
+				http.Error(w, "Invalid data", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				d.log.Error(err.Error())
+
+				http.Error(w, "Error", http.StatusBadRequest)
+				return
+			}
+
+			fmt.Fprint(w, id)
+			return
+		}
+
 		if params.DashboardId != nil {
 			_, err = d.rights.CheckDashboardRight(ctx, params.UserId, *params.DashboardId, params.Type)
 		} else if params.WidgetId != nil {
@@ -145,7 +180,7 @@ func (d *rightsHelper) Create(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		id, err := d.handlers.Create(ctx, params.DashboardId, params.WidgetId, params.UserId, params.Type)
+		id, err := d.handlers.Create(ctx, params.DashboardId, params.WidgetId, params.UserId, params.Type, actorId)
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -154,10 +189,55 @@ func (d *rightsHelper) Create(role models.GrantType) http.HandlerFunc {
 		}
 
 		fmt.Fprint(w, id)
+	}
+}
+
+func (d *rightsHelper) Share(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		params := struct {
+			DashboardId int              `json:"dashboardId"`
+			Type        models.GrantType `json:"type"`
+			ExpiresAt   *time.Time       `json:"expiresAt"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&params)
+
+		if _, known := models.ParseGrantType(string(params.Type)); err != nil || !known {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		err = d.validateRole(ctx, w, r, role, &params.DashboardId, nil, nil)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		right, err := d.handlers.CreateShareToken(ctx, params.DashboardId, params.Type, params.ExpiresAt)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
 
-		//todo ну это реально хреново
-		var q = fmt.Sprintf("{\"Type\":\"rights_create\", \"id\": %v, \"rightId\": %v}", params.UserId, id)
-		go producer.Write(fmt.Sprintf("nsi.%v", params.UserId), q)
+		result, err := json.Marshal(right)
+
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
 	}
 }
 
@@ -182,7 +262,12 @@ func (d *rightsHelper) Delete(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		err = d.validateRole(ctx, w, r, role, params.DashboardId, nil, nil)
+		if params.DashboardId == nil && params.WidgetId == nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		err = d.validateRole(ctx, w, r, role, params.DashboardId, params.WidgetId, nil)
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -190,7 +275,8 @@ func (d *rightsHelper) Delete(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		err = d.handlers.Delete(ctx, params.DashboardId, params.WidgetId, rightId)
+		actorId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		err = d.handlers.Delete(ctx, params.DashboardId, params.WidgetId, rightId, actorId)
 		if err != nil {
 			d.log.Error(err.Error())
 
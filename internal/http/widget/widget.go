@@ -3,17 +3,22 @@ package widgetController
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	grpcHandler "nsi/internal/auth"
 	models "nsi/internal/domain"
 	join_models "nsi/internal/domain/join"
-	producer "nsi/internal/kafka"
+	"nsi/internal/http/etag"
 	"strconv"
 	"time"
 )
 
+const maxPatchSize = 1 << 20
+
 type widgetHelper struct {
 	log      *slog.Logger
 	timeout  time.Duration
@@ -21,19 +26,28 @@ type widgetHelper struct {
 	rights   RightHandler
 }
 type RightHandler interface {
-	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType) (id int, err error)
+	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType, actorId int) (id int, err error)
+	Copy(ctx context.Context, fromDashboardId *int, fromWidgetId *int, toDashboardId *int, toWidgetId *int, actorId int) error
 
 	CheckDashboardRight(ctx context.Context, userId int, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error)
 	CheckWidgetRight(ctx context.Context, userId int, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error)
+
+	CheckDashboardTokenRight(ctx context.Context, token string, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error)
+	CheckWidgetTokenRight(ctx context.Context, token string, widgetId int, rightType models.GrantType) (right *models.AccessRight, err error)
 }
 
 type WidgetHandlers interface {
-	Create(ctx context.Context, name string, dashboardId int, widgetType models.WidgetType, config string, ownerId int, rightService RightHandler) (id int, err error)
-	Delete(ctx context.Context, id int) error
+	Create(ctx context.Context, name string, dashboardId int, widgetType models.WidgetType, config string, layout *models.WidgetLayout, ownerId int, rightService RightHandler) (id int, err error)
+	Delete(ctx context.Context, id int, actorId int) error
+	Copy(ctx context.Context, id int, dashboardId int, layout *models.WidgetLayout, actorId int, rightService RightHandler) (*models.Widget, error)
+	Move(ctx context.Context, id int, dashboardId int, layout *models.WidgetLayout, actorId int) (*models.Widget, error)
 	//Update(ctx context.Context, id int, widgetType models.GrantType) error
-	UpdatePos(ctx context.Context, id int, x, y float64) error
-	UpdateConfig(ctx context.Context, id int, config string) error
+	UpdateLayout(ctx context.Context, id int, change models.LayoutChange, version int, actorId int) (*models.Widget, error)
+	UpdateDashboardLayout(ctx context.Context, dashboardId int, updates []models.WidgetLayoutUpdate, actorId int) ([]models.Widget, error)
+	UpdateConfig(ctx context.Context, id int, config string, version int, actorId int) (*models.Widget, error)
+	PatchConfig(ctx context.Context, id int, format models.ConfigPatch, patch []byte, version int, actorId int) (*models.Widget, error)
 
+	GetWidget(ctx context.Context, id int) (*models.Widget, error)
 	GetByDashboard(ctx context.Context, userId int, dashboardId int) (*[]join_models.WidgetWithRight, error)
 	GetAllByDashboard(ctx context.Context, dashboardId int) (*[]join_models.WidgetWithRight, error)
 }
@@ -42,26 +56,120 @@ func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *gr
 	helper := &widgetHelper{logger, t, handlers, rights}
 
 	mux.HandleFunc("POST /widget/create", grpc.ValidateHandler(helper.Create(models.Update)))
-	mux.HandleFunc("PATCH /widget/pos/{id}", grpc.ValidateHandler(helper.UpdatePos(models.Update)))
-	mux.HandleFunc("PATCH /widget/{id}", grpc.ValidateHandler(helper.UpdateConfig(models.Update)))
+	mux.HandleFunc("PATCH /widget/pos/{id}", grpc.ShareHandler(helper.UpdateLayout(models.Update)))
+	mux.HandleFunc("PATCH /widget/{id}", grpc.ShareHandler(helper.UpdateConfig(models.Update)))
+	mux.HandleFunc("POST /widget/{id}/copy", grpc.ValidateHandler(helper.Copy(models.Update)))
+	mux.HandleFunc("POST /widget/{id}/move", grpc.ValidateHandler(helper.Move(models.Update)))
+	mux.HandleFunc("PATCH /dashboard/{id}/layout", grpc.ShareHandler(helper.UpdateDashboardLayout(models.Update)))
 
 	mux.HandleFunc("DELETE /widget/{id}", grpc.ValidateHandler(helper.Delete(models.Admin)))
-	mux.HandleFunc("GET /widgets", grpc.ValidateHandler(helper.GetWidgets(models.ReadOnly)))
+	mux.HandleFunc("GET /widgets", grpc.ShareHandler(helper.GetWidgets(models.ReadOnly)))
+	mux.HandleFunc("GET /widget-types", grpc.ValidateHandler(helper.GetWidgetTypes()))
 }
 
 func (d *widgetHelper) validateRoleWidget(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType, dashboardId int) error {
 	userId, _ := strconv.Atoi(r.Header.Get("UserId"))
 	_, err := d.rights.CheckWidgetRight(ctx, userId, dashboardId, role)
+	if token := r.Header.Get(grpcHandler.ShareTokenHeader); err != nil && token != "" {
+		_, err = d.rights.CheckWidgetTokenRight(ctx, token, dashboardId, role)
+	}
 	return err
 }
-func (d *widgetHelper) validateRoleDashboard(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType, dashboardId int) (*models.GrantType, error) {
+func (d *widgetHelper) validateRoleDashboard(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType, dashboardId int) (*models.AccessRight, error) {
 	userId, _ := strconv.Atoi(r.Header.Get("UserId"))
 	result, err := d.rights.CheckDashboardRight(ctx, userId, dashboardId, role)
-	if result == nil {
-		return nil, err
+	if token := r.Header.Get(grpcHandler.ShareTokenHeader); err != nil && token != "" {
+		result, err = d.rights.CheckDashboardTokenRight(ctx, token, dashboardId, role)
+	}
+
+	return result, err
+}
+
+// invalidConfig answers a config rejected by the widget type schema with 422 and the field errors,
+// it reports false when err is something else.
+func (d *widgetHelper) invalidConfig(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, models.ErrUnknownWidgetType) {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return true
+	}
+
+	var configErr *models.ConfigError
+	if !errors.As(err, &configErr) {
+		return false
+	}
+
+	result, err := json.Marshal(configErr)
+	if err != nil {
+		http.Error(w, "Error", http.StatusBadRequest)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(result)
+	return true
+}
+
+// provisioned answers a write to a provisioned dashboard with 409, it reports false when err is something else.
+func (d *widgetHelper) provisioned(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, models.ErrProvisioned) {
+		return false
 	}
 
-	return &result.Type, err
+	http.Error(w, err.Error(), http.StatusConflict)
+	return true
+}
+
+// invalidLayout answers a layout the dashboard grid does not allow, it reports false when err is something else.
+func (d *widgetHelper) invalidLayout(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, models.ErrInvalidLayout):
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+	case errors.Is(err, models.ErrLayoutOverlap), errors.Is(err, models.ErrWidgetLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *widgetHelper) GetWidgetTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		result, err := json.Marshal(models.WidgetTypes())
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
+	}
+}
+
+// ifMatch reads the version a write is based on, concurrent editors would silently overwrite each other without it.
+func (d *widgetHelper) ifMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		http.Error(w, "If-Match required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+
+	version, ok := etag.Parse(header)
+	if !ok {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return version, true
+}
+
+// patchFormats maps the Content-Type of PATCH /widget/{id} to a partial update, anything else replaces the config.
+var patchFormats = map[string]models.ConfigPatch{
+	"application/merge-patch+json": models.MergePatch,
+	"application/json-patch+json":  models.JSONPatch,
 }
 
 func (d *widgetHelper) UpdateConfig(role models.GrantType) http.HandlerFunc {
@@ -71,8 +179,14 @@ func (d *widgetHelper) UpdateConfig(role models.GrantType) http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
 		defer cancel()
 
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if format, ok := patchFormats[mediaType]; ok {
+			d.patchConfig(ctx, w, r, role, format)
+			return
+		}
+
 		params := struct {
-			Config string `json:"config"` // not safe. todo. исправить уязвимости, всё сломается если навести суету через девтул
+			Config string `json:"config"` // validated against the widget type schema by the service
 		}{}
 
 		err := json.NewDecoder(r.Body).Decode(&params)
@@ -88,6 +202,11 @@ func (d *widgetHelper) UpdateConfig(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
+		version, ok := d.ifMatch(w, r)
+		if !ok {
+			return
+		}
+
 		err = d.validateRoleWidget(ctx, w, r, role, int(id))
 		if err != nil {
 			d.log.Error(err.Error())
@@ -96,7 +215,19 @@ func (d *widgetHelper) UpdateConfig(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		err = d.handlers.UpdateConfig(ctx, int(id), params.Config)
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		model, err := d.handlers.UpdateConfig(ctx, int(id), params.Config, version, userId)
+		if d.provisioned(w, err) {
+			return
+		}
+		if errors.Is(err, models.ErrVersionConflict) {
+			etag.PreconditionFailed(w, model.Version, model)
+			return
+		}
+		if d.invalidConfig(w, err) {
+			return
+		}
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -104,27 +235,79 @@ func (d *widgetHelper) UpdateConfig(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		//спрятать в сервис уровень todo Не работает если LDS не слушает, надо что то придумать
-		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+		w.Header().Set("ETag", etag.Format(model.Version))
+	}
+}
 
-		var q = fmt.Sprintf("{\"Type\":\"widget_update_config\", \"id\": %v, \"config\":%v}", id, params.Config)
+// patchConfig applies a merge or JSON patch. If-Match is optional here: a patch only touches the keys it names,
+// so without it the patch is applied on top of concurrent edits instead of being rejected.
+func (d *widgetHelper) patchConfig(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType, format models.ConfigPatch) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
 
-		//todo хуйня полная
-		go producer.Write(fmt.Sprintf("nsi.%v", userId), q)
+	version := 0
+	if header := r.Header.Get("If-Match"); header != "" {
+		var ok bool
+		if version, ok = etag.Parse(header); !ok {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil || !json.Valid(patch) {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	err = d.validateRoleWidget(ctx, w, r, role, int(id))
+	if err != nil {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+	model, err := d.handlers.PatchConfig(ctx, int(id), format, patch, version, userId)
+	if d.provisioned(w, err) {
+		return
+	}
+	if errors.Is(err, models.ErrVersionConflict) {
+		etag.PreconditionFailed(w, model.Version, model)
+		return
+	}
+	if d.invalidConfig(w, err) {
+		return
+	}
+	if errors.Is(err, models.ErrInvalidPatch) {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Invalid patch", http.StatusUnprocessableEntity)
+		return
 	}
+	if err != nil {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Format(model.Version))
 }
 
-func (d *widgetHelper) UpdatePos(role models.GrantType) http.HandlerFunc {
+func (d *widgetHelper) UpdateLayout(role models.GrantType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
 
 		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
 		defer cancel()
 
-		params := struct {
-			X float64 `json:"x"`
-			Y float64 `json:"y"`
-		}{}
+		params := models.LayoutChange{}
 
 		err := json.NewDecoder(r.Body).Decode(&params)
 
@@ -139,6 +322,11 @@ func (d *widgetHelper) UpdatePos(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
+		version, ok := d.ifMatch(w, r)
+		if !ok {
+			return
+		}
+
 		err = d.validateRoleWidget(ctx, w, r, role, int(id))
 		if err != nil {
 			d.log.Error(err.Error())
@@ -147,7 +335,19 @@ func (d *widgetHelper) UpdatePos(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		err = d.handlers.UpdatePos(ctx, int(id), params.X, params.Y)
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		model, err := d.handlers.UpdateLayout(ctx, int(id), params, version, userId)
+		if d.provisioned(w, err) {
+			return
+		}
+		if errors.Is(err, models.ErrVersionConflict) {
+			etag.PreconditionFailed(w, model.Version, model)
+			return
+		}
+		if d.invalidLayout(w, err) {
+			return
+		}
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -155,13 +355,195 @@ func (d *widgetHelper) UpdatePos(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		//спрятать в сервис уровень todo Не работает если LDS не слушает, надо что то придумать
+		w.Header().Set("ETag", etag.Format(model.Version))
+	}
+}
+
+// UpdateDashboardLayout moves many widgets of one dashboard at once, with a single right check and a single
+// layout_changed event. The version of each entry is optional since the whole change is applied atomically.
+func (d *widgetHelper) UpdateDashboardLayout(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		params := []models.WidgetLayoutUpdate{}
+
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize)).Decode(&params)
+
+		if err != nil || len(params) == 0 {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
+		_, err = d.validateRoleDashboard(ctx, w, r, role, int(id))
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		model, err := d.handlers.UpdateDashboardLayout(ctx, int(id), params, userId)
+		if d.provisioned(w, err) {
+			return
+		}
+		if errors.Is(err, models.ErrVersionConflict) {
+			d.layoutConflict(w, model)
+			return
+		}
+		if d.invalidLayout(w, err) {
+			return
+		}
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		result, err := json.Marshal(model)
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, string(result))
+	}
+}
+
+// layoutConflict answers a bulk layout change based on stale widgets with 412 and their current state.
+func (d *widgetHelper) layoutConflict(w http.ResponseWriter, current []models.Widget) {
+	result, err := json.Marshal(current)
+	if err != nil {
+		http.Error(w, "Error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	w.Write(result)
+}
+
+// transferParams reads the target of a copy or move and checks role on both the source and the target dashboard.
+func (d *widgetHelper) transferParams(ctx context.Context, w http.ResponseWriter, r *http.Request, role models.GrantType) (id int, dashboardId int, layout *models.WidgetLayout, source *models.Widget, ok bool) {
+	params := struct {
+		DashboardId int                  `json:"dashboardId"`
+		Layout      *models.WidgetLayout `json:"layout"` // placed below the target's widgets when omitted
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+
+	if err != nil {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	source, err = d.handlers.GetWidget(ctx, int(parsed))
+	if err != nil {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Error", http.StatusBadRequest)
+		return
+	}
+
+	_, err = d.validateRoleDashboard(ctx, w, r, role, source.DashboardId)
+	if err == nil {
+		_, err = d.validateRoleDashboard(ctx, w, r, role, params.DashboardId)
+	}
+	if err != nil {
+		d.log.Error(err.Error())
+
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	return int(parsed), params.DashboardId, params.Layout, source, true
+}
+
+func (d *widgetHelper) Copy(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, dashboardId, layout, _, ok := d.transferParams(ctx, w, r, role)
+		if !ok {
+			return
+		}
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		model, err := d.handlers.Copy(ctx, id, dashboardId, layout, userId, d.rights)
+		if d.provisioned(w, err) {
+			return
+		}
+		if d.invalidLayout(w, err) {
+			return
+		}
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, model.Id)
+	}
+}
+
+func (d *widgetHelper) Move(role models.GrantType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		id, dashboardId, layout, source, ok := d.transferParams(ctx, w, r, role)
+		if !ok {
+			return
+		}
+
+		if dashboardId == source.DashboardId {
+			http.Error(w, "Invalid data", http.StatusBadRequest)
+			return
+		}
+
 		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
 
-		var q = fmt.Sprintf("{\"Type\":\"widget_update_pos\", \"id\": %v, \"x\": %v, \"y\": %v}", id, params.X, params.Y)
+		model, err := d.handlers.Move(ctx, id, dashboardId, layout, userId)
+		if d.provisioned(w, err) {
+			return
+		}
+		if d.invalidLayout(w, err) {
+			return
+		}
+		if err != nil {
+			d.log.Error(err.Error())
+
+			http.Error(w, "Error", http.StatusBadRequest)
+			return
+		}
 
-		//todo хуйня полная
-		go producer.Write(fmt.Sprintf("nsi.%v", userId), q)
+		w.Header().Set("ETag", etag.Format(model.Version))
 	}
 }
 
@@ -186,20 +568,18 @@ func (d *widgetHelper) Delete(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		err = d.handlers.Delete(ctx, int(id))
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		err = d.handlers.Delete(ctx, int(id), userId)
+		if d.provisioned(w, err) {
+			return
+		}
 		if err != nil {
 			d.log.Error(err.Error())
 
 			http.Error(w, "Error", http.StatusBadRequest)
 			return
 		}
-
-		//спрятать в сервис уровень todo Не работает если LDS не слушает, надо что то придумать
-		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
-		var q = fmt.Sprintf("{\"Type\":\"widget_delete\", \"id\": %v, \"widgetId\": %v}", userId, id)
-
-		//todo хуйня полная
-		go producer.Write(fmt.Sprintf("nsi.%v", userId), q)
 	}
 }
 
@@ -227,12 +607,24 @@ func (d *widgetHelper) GetWidgets(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
+		if grant == nil {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
 		var widgets *[]join_models.WidgetWithRight
 
-		if grant != nil {
-			widgets, err = d.handlers.GetByDashboard(ctx, userId, dashboardId)
-		} else if grant != nil && *grant == models.Admin {
+		if grant.AccessToken != nil {
+			// share tokens see the whole dashboard with the token grant
 			widgets, err = d.handlers.GetAllByDashboard(ctx, dashboardId)
+			if err == nil {
+				for i := range *widgets {
+					(*widgets)[i].AccessType = grant.Type
+				}
+			}
+		} else {
+			// users, dashboard admins included, see the widgets they hold a right on
+			widgets, err = d.handlers.GetByDashboard(ctx, userId, dashboardId)
 		}
 
 		if err != nil {
@@ -263,12 +655,11 @@ func (d *widgetHelper) Create(role models.GrantType) http.HandlerFunc {
 		defer cancel()
 
 		params := struct {
-			Name        string `json:"name"`
-			DashboardId int    `json:"dashboardId"`
-			WidgetType  string `json:"type"`
-			Config      string `json:"config"`
-
-			WidgetId int
+			Name        string               `json:"name"`
+			DashboardId int                  `json:"dashboardId"`
+			WidgetType  string               `json:"type"`
+			Config      string               `json:"config"`
+			Layout      *models.WidgetLayout `json:"layout"` // placed below the existing widgets when omitted
 		}{}
 
 		err := json.NewDecoder(r.Body).Decode(&params)
@@ -288,7 +679,13 @@ func (d *widgetHelper) Create(role models.GrantType) http.HandlerFunc {
 
 		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
 
-		id, err := d.handlers.Create(ctx, params.Name, params.DashboardId, models.WidgetType(params.WidgetType), params.Config, userId, d.rights)
+		id, err := d.handlers.Create(ctx, params.Name, params.DashboardId, models.WidgetType(params.WidgetType), params.Config, params.Layout, userId, d.rights)
+		if d.provisioned(w, err) {
+			return
+		}
+		if d.invalidConfig(w, err) || d.invalidLayout(w, err) {
+			return
+		}
 		if err != nil {
 			d.log.Error(err.Error())
 
@@ -296,13 +693,6 @@ func (d *widgetHelper) Create(role models.GrantType) http.HandlerFunc {
 			return
 		}
 
-		params.WidgetId = id
-
 		fmt.Fprint(w, id)
-
-		params_str, _ := json.Marshal(params)
-
-		var q = fmt.Sprintf("{\"Type\":\"widget_create\", \"Metadata\": %v}", string(params_str))
-		go producer.Write(fmt.Sprintf("nsi.%v", userId), q)
 	}
 }
@@ -0,0 +1,182 @@
+package wsController
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	grpcHandler "nsi/internal/auth"
+	models "nsi/internal/domain"
+	"nsi/internal/realtime"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+
+	maxMessageSize = 4096
+)
+
+type wsHelper struct {
+	log      *slog.Logger
+	timeout  time.Duration
+	hub      *realtime.Hub
+	rights   RightHandler
+	upgrader websocket.Upgrader
+}
+
+type RightHandler interface {
+	CheckDashboardRight(ctx context.Context, userId int, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error)
+}
+
+func Register(logger *slog.Logger, mux *http.ServeMux, t time.Duration, grpc *grpcHandler.Handler, hub *realtime.Hub, rights RightHandler) {
+	helper := &wsHelper{logger, t, hub, rights, websocket.Upgrader{
+		// origins are as open as the cors config of the rest of the api
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}}
+
+	mux.HandleFunc("GET /ws", grpc.StreamHandler(helper.Connect()))
+}
+
+// Connect upgrades to a websocket streaming event envelopes. Without ?dashboardId= the socket receives events
+// of every dashboard the user can read, otherwise only of the listed ones. Sending
+// {"action": "join", "dashboardId": 1, "state": "editing"} puts the socket on the dashboard's presence list,
+// after which "heartbeat", "pointer", "selection" and "leave" are relayed to the others there.
+func (d *wsHelper) Connect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.log.Info(fmt.Sprintf("[%v] [%v] request", r.Method, r.URL.Path))
+
+		userId, _ := strconv.Atoi(r.Header.Get("UserId"))
+
+		var dashboards []int
+		for _, raw := range r.URL.Query()["dashboardId"] {
+			dashboardId, err := strconv.Atoi(raw)
+			if err != nil {
+				http.Error(w, "Invalid data", http.StatusBadRequest)
+				return
+			}
+			dashboards = append(dashboards, dashboardId)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		for _, dashboardId := range dashboards {
+			if _, err := d.rights.CheckDashboardRight(ctx, userId, dashboardId, models.ReadOnly); err != nil {
+				cancel()
+				d.log.Error(err.Error())
+
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+		}
+		cancel()
+
+		conn, err := d.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			d.log.Error(err.Error())
+			return
+		}
+		defer conn.Close()
+
+		client := d.hub.Register(userId, dashboards)
+		defer d.hub.Unregister(client)
+
+		closed := make(chan struct{})
+		go d.read(conn, client, closed)
+
+		d.write(conn, client, closed)
+	}
+}
+
+// command is a client frame, presence is the only thing a socket can send.
+type command struct {
+	Action      string               `json:"action"`
+	DashboardId int                  `json:"dashboardId"`
+	State       models.PresenceState `json:"state"`
+	Pointer     *models.Pointer      `json:"pointer"`
+	Selection   []int                `json:"selection"`
+}
+
+// read handles control frames and presence commands until the client disconnects.
+func (d *wsHelper) read(conn *websocket.Conn, client *realtime.Client, closed chan struct{}) {
+	defer close(closed)
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var params command
+		if err := json.Unmarshal(message, &params); err != nil {
+			continue
+		}
+
+		switch params.Action {
+		case "join":
+			role := models.ReadOnly
+			if params.State == models.PresenceEditing {
+				role = models.Update
+			} else {
+				params.State = models.PresenceViewing
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+			_, err := d.rights.CheckDashboardRight(ctx, client.UserId, params.DashboardId, role)
+			cancel()
+			if err != nil {
+				d.log.Error(err.Error())
+				continue
+			}
+
+			d.hub.Join(client, params.DashboardId, params.State)
+		case "leave":
+			d.hub.Leave(client, params.DashboardId)
+		case "heartbeat":
+			d.hub.Heartbeat(client, params.DashboardId)
+		case "pointer":
+			d.hub.Point(client, params.DashboardId, params.Pointer)
+		case "selection":
+			d.hub.Select(client, params.DashboardId, params.Selection)
+		}
+	}
+}
+
+func (d *wsHelper) write(conn *websocket.Conn, client *realtime.Client, closed chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case message, ok := <-client.Messages():
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// dropped by the hub, the client is expected to reconnect
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""))
+				return
+			}
+
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
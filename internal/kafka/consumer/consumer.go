@@ -9,16 +9,12 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-const (
-	brokerAddress = "localhost:9092"
-)
-
-func Consume(ctx context.Context, topic string) {
+func Consume(ctx context.Context, brokers []string, topic string) {
 
 	groupID := fmt.Sprintf("consumer-group-%d", os.Getpid())
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{brokerAddress},
+		Brokers:     brokers,
 		Topic:       topic,
 		GroupID:     groupID,
 		StartOffset: kafka.LastOffset,
@@ -33,9 +29,10 @@ func Consume(ctx context.Context, topic string) {
 			log.Println("Context canceled, stopping consumer")
 			return
 		default:
-			msg, err := reader.ReadMessage(context.Background())
+			msg, err := reader.ReadMessage(ctx)
 			if err != nil {
-				log.Fatalf("Error reading message: %v", err)
+				log.Printf("Error reading message: %v", err)
+				return
 			}
 
 			reader.CommitMessages(context.Background(), msg)
@@ -2,47 +2,39 @@ package producer
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
-const (
-	brokerAddress = "localhost:9092"
-)
-
-func Write(topic string, messageContent string) {
-	Init(topic)
+// Producer is an events.Publisher sharing one kafka writer, and its connections, between all topics.
+type Producer struct {
+	log    *slog.Logger
+	writer *kafka.Writer
+}
 
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{brokerAddress},
-		Topic:   topic,
-	})
+func New(log *slog.Logger, brokers []string) *Producer {
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.LeastBytes{},
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
 
-	defer writer.Close()
+	return &Producer{log, writer}
+}
 
-	// Отправляем сообщение
-	err := writer.WriteMessages(context.Background(),
+func (p *Producer) Publish(ctx context.Context, topic string, payload []byte) error {
+	return p.writer.WriteMessages(ctx,
 		kafka.Message{
+			Topic: topic,
 			Key:   []byte("event"),
-			Value: []byte(messageContent),
+			Value: payload,
 		},
 	)
-
-	if err != nil {
-		log.Fatalf("Failed to send message: %v", err)
-	}
-
-	fmt.Println("Message sent successfully!")
 }
 
-func Init(topic string) {
-
-	conn, err := kafka.DialLeader(context.Background(), "tcp", brokerAddress, topic, 0)
-	if err != nil {
-		panic(err)
-	}
-
-	defer conn.Close()
+func (p *Producer) Close() error {
+	return p.writer.Close()
 }
@@ -0,0 +1,118 @@
+package producer
+
+import (
+	"context"
+	"log/slog"
+	models "nsi/internal/domain"
+	"nsi/internal/events"
+	"time"
+)
+
+const (
+	relayBatch      = 100
+	relayMinBackoff = time.Second
+	relayMaxBackoff = 5 * time.Minute
+	relayRetention  = 24 * time.Hour
+	relayPurgeEvery = time.Hour
+)
+
+type RelayStore interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	FetchPendingEvents(ctx context.Context, limit int) ([]models.OutboxMessage, error)
+	MarkEventSent(ctx context.Context, id int64) error
+	MarkEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error
+	PurgeSentEvents(ctx context.Context, before time.Time) error
+}
+
+// Relay moves events from the outbox table to the publisher, retrying failed ones with exponential backoff.
+type Relay struct {
+	log       *slog.Logger
+	store     RelayStore
+	publisher events.Publisher
+	interval  time.Duration
+}
+
+func NewRelay(log *slog.Logger, store RelayStore, publisher events.Publisher, interval time.Duration) *Relay {
+	return &Relay{log, store, publisher, interval}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	const op = "producer.Relay.Run"
+
+	log := r.log.With(slog.String("op", op))
+	log.Info("starting outbox relay")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	lastPurge := time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+
+		// drain everything due before waiting for the next tick
+		for {
+			sent, err := r.relayBatch(ctx)
+			if err != nil {
+				log.Error(err.Error())
+				break
+			}
+			if sent < relayBatch {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) > relayPurgeEvery {
+			if err := r.store.PurgeSentEvents(ctx, time.Now().Add(-relayRetention)); err != nil {
+				log.Error(err.Error())
+			}
+			lastPurge = time.Now()
+		}
+	}
+}
+
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	count := 0
+
+	err := r.store.WithTx(ctx, func(ctx context.Context) error {
+		messages, err := r.store.FetchPendingEvents(ctx, relayBatch)
+		if err != nil {
+			return err
+		}
+		count = len(messages)
+
+		for _, message := range messages {
+			err := r.publisher.Publish(ctx, message.Topic, message.Payload)
+			if err != nil {
+				// the broker is likely down, keep the rest of the batch for the next tick
+				r.log.Warn("outbox publish failed", slog.Int64("id", message.Id), slog.Int("attempts", message.Attempts+1), slog.String("error", err.Error()))
+				count = 0
+
+				return r.store.MarkEventFailed(ctx, message.Id, time.Now().Add(backoff(message.Attempts)), err.Error())
+			}
+
+			if err := r.store.MarkEventSent(ctx, message.Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func backoff(attempts int) time.Duration {
+	delay := relayMinBackoff
+	for i := 0; i < attempts && delay < relayMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, relayMaxBackoff)
+}
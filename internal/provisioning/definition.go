@@ -0,0 +1,179 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	models "nsi/internal/domain"
+	document_models "nsi/internal/domain/document"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// definition is one dashboard file. YAML files are read into the same shape as JSON ones, so both use the field
+// names of the JSON tags:
+//
+//	key: ops-overview
+//	name: Ops overview
+//	parentId: 12
+//	grid: {columns: 12, rowHeight: 30, snap: true, collision: true}
+//	rights:
+//	  - {groupId: 3, type: read}
+//	  - {userId: 7, type: admin}
+//	widgets:
+//	  - key: cpu
+//	    name: CPU
+//	    type: line_chart
+//	    config: {...}
+//	    layout: {x: 0, y: 0, w: 6, h: 4}
+type definition struct {
+	Key      string                `json:"key"`
+	Name     string                `json:"name"`
+	ParentId *int                  `json:"parentId"`
+	Grid     *document_models.Grid `json:"grid"`   // models.DefaultGrid when missing
+	Rights   []rightDefinition     `json:"rights"` // granted on the dashboard and each of its widgets
+	Widgets  []widgetDefinition    `json:"widgets"`
+}
+
+// rightDefinition grants Type to either a user or a group.
+type rightDefinition struct {
+	UserId  *int             `json:"userId"`
+	GroupId *int             `json:"groupId"`
+	Type    models.GrantType `json:"type"`
+}
+
+// grantee identifies who a right is for, the unused id is 0.
+type grantee struct {
+	userId  int
+	groupId int
+}
+
+func granteeOf(userId *int, groupId *int) grantee {
+	result := grantee{}
+	if userId != nil {
+		result.userId = *userId
+	}
+	if groupId != nil {
+		result.groupId = *groupId
+	}
+	return result
+}
+
+type widgetDefinition struct {
+	Key    string                 `json:"key"`
+	Name   string                 `json:"name"`
+	Type   models.WidgetType      `json:"type"`
+	Config json.RawMessage        `json:"config"` // the type's default when missing
+	Layout document_models.Layout `json:"layout"` // w and h default to the widget default size
+}
+
+var definitionExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+func readDefinition(path string) (*definition, []byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content := raw
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".json" {
+		var value any
+		if err := yaml.Unmarshal(raw, &value); err != nil {
+			return nil, raw, err
+		}
+		if content, err = json.Marshal(value); err != nil {
+			return nil, raw, err
+		}
+	}
+
+	result := &definition{}
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(result); err != nil {
+		return nil, raw, err
+	}
+
+	result.applyDefaults()
+	return result, raw, result.validate()
+}
+
+func (d *definition) applyDefaults() {
+	if d.Grid == nil {
+		grid := document_models.GridOf(models.DefaultGrid)
+		d.Grid = &grid
+	}
+
+	for i := range d.Widgets {
+		widget := &d.Widgets[i]
+		if len(widget.Config) == 0 {
+			widget.Config = json.RawMessage(widget.Type.DefaultConfig())
+		}
+		if widget.Layout.W == 0 {
+			widget.Layout.W = models.DefaultWidgetWidth
+		}
+		if widget.Layout.H == 0 {
+			widget.Layout.H = models.DefaultWidgetHeight
+		}
+	}
+}
+
+// validate checks the keys and, through the export document, everything creating the dashboard would check.
+func (d *definition) validate() error {
+	var problems []string
+	if d.Key == "" {
+		problems = append(problems, "key is required")
+	}
+
+	grantees := make(map[grantee]bool, len(d.Rights))
+	for i, right := range d.Rights {
+		if (right.UserId == nil) == (right.GroupId == nil) {
+			problems = append(problems, fmt.Sprintf("rights/%d: exactly one of userId and groupId is required", i))
+		}
+		if _, known := models.ParseGrantType(string(right.Type)); !known {
+			problems = append(problems, fmt.Sprintf("rights/%d: unknown type %q", i, right.Type))
+		}
+
+		key := granteeOf(right.UserId, right.GroupId)
+		if grantees[key] {
+			problems = append(problems, fmt.Sprintf("rights/%d: granted twice", i))
+		}
+		grantees[key] = true
+	}
+
+	keys := make(map[string]bool, len(d.Widgets))
+	document := document_models.Document{Version: document_models.Version, Dashboard: document_models.Dashboard{
+		Name: d.Name,
+		Grid: *d.Grid,
+	}}
+	for i, widget := range d.Widgets {
+		if widget.Key == "" || keys[widget.Key] {
+			problems = append(problems, fmt.Sprintf("widgets/%d: key is missing or not unique", i))
+		}
+		keys[widget.Key] = true
+
+		document.Dashboard.Widgets = append(document.Dashboard.Widgets, document_models.Widget{
+			Id:     i,
+			Name:   widget.Name,
+			Type:   widget.Type,
+			Config: widget.Config,
+			Layout: widget.Layout,
+		})
+	}
+
+	var invalid *document_models.InvalidError
+	if err := document.Validate(); errors.As(err, &invalid) {
+		for _, problem := range invalid.Problems {
+			problems = append(problems, strings.TrimPrefix(problem.Path, "/dashboard/")+": "+problem.Message)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package provisioning
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	models "nsi/internal/domain"
+	join_models "nsi/internal/domain/join"
+	"nsi/internal/events"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Store interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	GetProvisionKeys(ctx context.Context) ([]string, error)
+	GetDashboardByProvisionKey(ctx context.Context, model *models.Dashboard) error
+	CreateDashboard(ctx context.Context, model *models.Dashboard) error
+	UpdateDashboard(ctx context.Context, model *models.Dashboard) error
+	GetAllWidgetsByDashboard(ctx context.Context, dashboardId int) (*[]join_models.WidgetWithRight, error)
+	CreateWidget(ctx context.Context, model *models.Widget) error
+	UpdateWidget(ctx context.Context, model *models.Widget) error
+	DeleteWidget(ctx context.Context, id int) error
+}
+
+// Rights grants access to provisioned dashboards and widgets, through the rights service so the changes are
+// announced like any other grant.
+type Rights interface {
+	GetRights(ctx context.Context, id int, isDasboard bool) ([]models.AccessRight, error)
+	Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType, actorId int) (id int, err error)
+	CreateForGroup(ctx context.Context, dashboardId *int, widgetdId *int, groupId int, grantType models.GrantType, actorId int) (id int, err error)
+	Delete(ctx context.Context, dashboardId *int, widgetdId *int, rightId int, actorId int) error
+}
+
+// History snapshots a dashboard as a new revision, called last in the transaction of the change it records.
+type History interface {
+	Record(ctx context.Context, dashboardId int, authorId int, change events.Type) error
+}
+
+// Provisioner applies the dashboard definitions of a directory at startup and whenever a file changes. Dashboards
+// and widgets are matched by their key, so renaming a file or moving the dashboard keeps its id. Provisioned
+// dashboards are read-only in the API; a difference found while the file itself is unchanged is logged as drift
+// and undone. The user and group rights of a definition are granted on the dashboard and each widget, rights
+// granted otherwise are revoked; share tokens are left alone.
+type Provisioner struct {
+	log      *slog.Logger
+	store    Store
+	rights   Rights
+	history  History
+	events   events.Emitter
+	path     string
+	interval time.Duration
+
+	applied  map[string]string // file -> hash of the content last applied or rejected
+	orphaned map[string]bool   // keys already reported as having no file
+}
+
+// emitter should publish transactionally (through events.Outbox) so events are stored together with the change.
+func New(log *slog.Logger, store Store, rights Rights, history History, emitter events.Emitter, path string, interval time.Duration) *Provisioner {
+	return &Provisioner{log, store, rights, history, emitter, path, interval, map[string]string{}, map[string]bool{}}
+}
+
+// Run applies the definitions right away and then polls the directory until ctx is cancelled.
+func (p *Provisioner) Run(ctx context.Context) {
+	const op = "provisioning.Provisioner.Run"
+
+	log := p.log.With(slog.String("op", op), slog.String("path", p.path))
+	log.Info("starting provisioning")
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.sync(ctx, log)
+
+		select {
+		case <-ctx.Done():
+			log.Info("provisioning stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Provisioner) sync(ctx context.Context, log *slog.Logger) {
+	var files []string
+	err := filepath.WalkDir(p.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && definitionExtensions[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	existing, err := p.store.GetProvisionKeys(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	keys := map[string]string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		seen[file] = true
+		log := log.With(slog.String("file", file))
+
+		definition, raw, err := readDefinition(file)
+		sum := sha256.Sum256(raw)
+		hash := hex.EncodeToString(sum[:])
+		changed := p.applied[file] != hash
+
+		if err == nil && keys[definition.Key] != "" {
+			err = fmt.Errorf("key %q is already defined in %v", definition.Key, keys[definition.Key])
+		}
+		if err != nil {
+			// reported once per content, not on every poll
+			if changed {
+				log.Error("invalid dashboard definition", slog.String("error", err.Error()))
+				p.applied[file] = hash
+			}
+			continue
+		}
+		keys[definition.Key] = file
+
+		differences, err := p.apply(ctx, definition, slices.Contains(existing, definition.Key))
+		if err != nil {
+			log.Error("applying dashboard definition failed", slog.String("key", definition.Key), slog.String("error", err.Error()))
+			continue
+		}
+		p.applied[file] = hash
+
+		switch {
+		case len(differences) == 0:
+		case changed:
+			log.Info("dashboard provisioned", slog.String("key", definition.Key), slog.Any("changes", differences))
+		default:
+			log.Warn("provisioned dashboard drifted from its definition, restored", slog.String("key", definition.Key), slog.Any("drift", differences))
+		}
+	}
+
+	for file := range p.applied {
+		if !seen[file] {
+			delete(p.applied, file)
+		}
+	}
+
+	// dashboards are never deleted because their file is gone, that is left to whoever removed it
+	for _, key := range existing {
+		if _, ok := keys[key]; !ok && !p.orphaned[key] {
+			log.Warn("provisioned dashboard has no definition anymore", slog.String("key", key))
+		}
+		p.orphaned[key] = keys[key] == ""
+	}
+}
+
+// apply upserts the dashboard and its widgets and lists what it had to change.
+func (p *Provisioner) apply(ctx context.Context, definition *definition, exists bool) (differences []string, err error) {
+	err = p.store.WithTx(ctx, func(ctx context.Context) error {
+		differences = nil
+
+		grid := definition.Grid.Settings()
+		model := &models.Dashboard{ProvisionKey: &definition.Key}
+
+		if !exists {
+			model = &models.Dashboard{Name: definition.Name, ParentId: definition.ParentId, Grid: grid, ProvisionKey: &definition.Key}
+			err := p.store.CreateDashboard(ctx, model)
+			if err != nil {
+				return err
+			}
+			differences = append(differences, "dashboard created")
+
+			payload := events.DashboardPayload{DashboardId: model.Id, Name: model.Name, ParentId: model.ParentId, Grid: events.GridOf(model.Grid)}
+			err = p.events.Emit(ctx, events.DashboardCreate, 0, &model.Id, payload)
+			if err != nil {
+				return err
+			}
+		} else {
+			err := p.store.GetDashboardByProvisionKey(ctx, model)
+			if err != nil {
+				return err
+			}
+
+			if model.Name != definition.Name {
+				differences = append(differences, "name")
+			}
+			if !reflect.DeepEqual(model.ParentId, definition.ParentId) {
+				differences = append(differences, "parentId")
+			}
+			if model.Grid != grid {
+				differences = append(differences, "grid")
+			}
+
+			if len(differences) > 0 {
+				model.Name, model.ParentId, model.Grid, model.Version = definition.Name, definition.ParentId, grid, 0
+				err := p.store.UpdateDashboard(ctx, model)
+				if err != nil {
+					return err
+				}
+
+				payload := events.DashboardPayload{DashboardId: model.Id, Name: model.Name, ParentId: model.ParentId, Grid: events.GridOf(model.Grid)}
+				err = p.events.Emit(ctx, events.DashboardUpdate, 0, &model.Id, payload)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		granted, err := p.syncRights(ctx, &model.Id, nil, definition.Rights)
+		if err != nil {
+			return err
+		}
+		if granted {
+			differences = append(differences, "rights")
+		}
+
+		widgets, err := p.store.GetAllWidgetsByDashboard(ctx, model.Id)
+		if err != nil {
+			return err
+		}
+
+		current := map[string]models.Widget{}
+		var stray []models.Widget
+		for _, widget := range *widgets {
+			if widget.ProvisionKey == nil {
+				stray = append(stray, widget.Widget)
+				continue
+			}
+			current[*widget.ProvisionKey] = widget.Widget
+		}
+
+		for _, widget := range definition.Widgets {
+			layout, err := grid.Fit(widget.Layout.WidgetLayout())
+			if err != nil {
+				return err
+			}
+
+			desired := &models.Widget{
+				Name:         widget.Name,
+				DashboardId:  model.Id,
+				WidgetType:   widget.Type,
+				Config:       string(widget.Config),
+				Layout:       layout,
+				ProvisionKey: &widget.Key,
+			}
+
+			existing, ok := current[widget.Key]
+			delete(current, widget.Key)
+
+			if !ok {
+				err = p.store.CreateWidget(ctx, desired)
+				if err != nil {
+					return err
+				}
+				differences = append(differences, fmt.Sprintf("widget %v created", widget.Key))
+
+				// granted first, the rights of the widget make up the audience of its event
+				_, err = p.syncRights(ctx, nil, &desired.Id, definition.Rights)
+				if err != nil {
+					return err
+				}
+
+				err = p.events.Emit(ctx, events.WidgetCreate, 0, &model.Id, events.WidgetCreateOf(*desired))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			granted, err := p.syncRights(ctx, nil, &existing.Id, definition.Rights)
+			if err != nil {
+				return err
+			}
+			if granted {
+				differences = append(differences, fmt.Sprintf("widget %v rights", widget.Key))
+			}
+
+			fields := existing.Differences(*desired)
+			if len(fields) == 0 {
+				continue
+			}
+			differences = append(differences, fmt.Sprintf("widget %v %v", widget.Key, strings.Join(fields, ", ")))
+
+			desired.Id = existing.Id
+			err = p.store.UpdateWidget(ctx, desired)
+			if err != nil {
+				return err
+			}
+
+			payload := events.WidgetConfigPayload{WidgetId: desired.Id, Config: json.RawMessage(desired.Config), Version: desired.Version}
+			err = p.events.Emit(ctx, events.WidgetUpdateConfig, 0, &model.Id, payload)
+			if err != nil {
+				return err
+			}
+
+			layoutPayload := events.WidgetLayoutPayload{WidgetId: desired.Id, Layout: events.LayoutOf(desired.Layout), Version: desired.Version}
+			err = p.events.Emit(ctx, events.WidgetUpdatePos, 0, &model.Id, layoutPayload)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, widget := range current {
+			stray = append(stray, widget)
+		}
+
+		for _, widget := range stray {
+			name := fmt.Sprint(widget.Id)
+			if widget.ProvisionKey != nil {
+				name = *widget.ProvisionKey
+			}
+			differences = append(differences, fmt.Sprintf("widget %v removed", name))
+
+			err = p.events.Emit(ctx, events.WidgetDelete, 0, &model.Id, events.WidgetDeletePayload{WidgetId: widget.Id})
+			if err != nil {
+				return err
+			}
+
+			err = p.store.DeleteWidget(ctx, widget.Id)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(differences) == 0 {
+			return nil
+		}
+
+		change := events.DashboardUpdate
+		if !exists {
+			change = events.DashboardCreate
+		}
+		return p.history.Record(ctx, model.Id, 0, change)
+	})
+
+	return differences, err
+}
+
+// syncRights makes the user and group rights of the dashboard or widget those of the definition and reports
+// whether anything had to change. A right of another type is replaced rather than updated.
+func (p *Provisioner) syncRights(ctx context.Context, dashboardId *int, widgetId *int, desired []rightDefinition) (bool, error) {
+	id, isDashboard := 0, dashboardId != nil
+	if isDashboard {
+		id = *dashboardId
+	} else {
+		id = *widgetId
+	}
+
+	current, err := p.rights.GetRights(ctx, id, isDashboard)
+	if err != nil {
+		return false, err
+	}
+
+	wanted := make(map[grantee]models.GrantType, len(desired))
+	for _, right := range desired {
+		wanted[granteeOf(right.UserId, right.GroupId)] = right.Type
+	}
+
+	changed := false
+	kept := map[grantee]bool{}
+	for _, right := range current {
+		if right.AccessToken != nil {
+			continue
+		}
+
+		key := granteeOf(right.UserId, right.UserGroupId)
+		if wanted[key] == right.Type && !kept[key] {
+			kept[key] = true
+			continue
+		}
+
+		err = p.rights.Delete(ctx, dashboardId, widgetId, right.Id, 0)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	for _, right := range desired {
+		if kept[granteeOf(right.UserId, right.GroupId)] {
+			continue
+		}
+
+		if right.UserId != nil {
+			_, err = p.rights.Create(ctx, dashboardId, widgetId, *right.UserId, right.Type, 0)
+		} else {
+			_, err = p.rights.CreateForGroup(ctx, dashboardId, widgetId, *right.GroupId, right.Type, 0)
+		}
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
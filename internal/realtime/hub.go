@@ -0,0 +1,202 @@
+package realtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	models "nsi/internal/domain"
+	"nsi/internal/events"
+	"sync"
+	"time"
+)
+
+// clientBuffer is how many events a client may fall behind before it gets disconnected.
+const clientBuffer = 64
+
+// Client is one live connection, Messages yields serialized envelopes and is closed once the hub drops it.
+type Client struct {
+	Id         string
+	UserId     int
+	dashboards map[int]bool
+	joined     map[int]bool
+	send       chan []byte
+}
+
+func newClient(userId int) *Client {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &Client{Id: hex.EncodeToString(id), UserId: userId, joined: make(map[int]bool), send: make(chan []byte, clientBuffer)}
+}
+
+func (c *Client) Messages() <-chan []byte {
+	return c.send
+}
+
+// Hub pushes envelopes published on nsi.<userId> topics to that user's open connections. It only sees events
+// emitted by this instance, handed over once their change has committed (see events.AfterCommit).
+type Hub struct {
+	log      *slog.Logger
+	mu       sync.Mutex
+	clients  map[int]map[*Client]struct{}
+	events   *eventLog
+	presence map[int]map[*Client]*models.PresenceMember
+
+	lastPresenceSweep time.Time
+}
+
+func NewHub(log *slog.Logger) *Hub {
+	return &Hub{
+		log:      log,
+		clients:  make(map[int]map[*Client]struct{}),
+		events:   newEventLog(),
+		presence: make(map[int]map[*Client]*models.PresenceMember),
+	}
+}
+
+// Register subscribes userId to events of the given dashboards, or to everything the user can read when none are given.
+// The caller is responsible for checking read rights on dashboards beforehand.
+func (h *Hub) Register(userId int, dashboards []int) *Client {
+	client := newClient(userId)
+	if len(dashboards) > 0 {
+		client.dashboards = make(map[int]bool, len(dashboards))
+		for _, id := range dashboards {
+			client.dashboards[id] = true
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.register(client)
+
+	return client
+}
+
+// Subscribe registers userId for a single dashboard and returns the events published after lastId, so the
+// caller can replay them before streaming. Only events that were published to userId are replayed, so widget
+// events keep the audience the dispatcher gave them. resumed is false when lastId has already been evicted from the log.
+func (h *Hub) Subscribe(userId int, dashboardId int, lastId string) (client *Client, backlog [][]byte, resumed bool) {
+	client = newClient(userId)
+	client.dashboards = map[int]bool{dashboardId: true}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastId != "" {
+		backlog, resumed = h.events.since(dashboardId, lastId, userId)
+	}
+	h.register(client)
+
+	return client, backlog, resumed
+}
+
+func (h *Hub) register(client *Client) {
+	userId := client.UserId
+	if h.clients[userId] == nil {
+		h.clients[userId] = make(map[*Client]struct{})
+	}
+	h.clients[userId][client] = struct{}{}
+}
+
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unregister(client)
+}
+
+func (h *Hub) unregister(client *Client) {
+	clients, ok := h.clients[client.UserId]
+	if !ok {
+		return
+	}
+	if _, ok := clients[client]; !ok {
+		return
+	}
+
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(h.clients, client.UserId)
+	}
+
+	// off every presence list before the channel closes, the leave broadcasts may drop further clients and must
+	// not reach this one
+	left := make(map[int]*models.PresenceMember, len(client.joined))
+	for dashboardId := range client.joined {
+		if member, ok := h.forget(client, dashboardId); ok {
+			left[dashboardId] = member
+		}
+	}
+	close(client.send)
+
+	for dashboardId, member := range left {
+		h.broadcastPresence(client, dashboardId, events.PresenceLeave, member)
+	}
+}
+
+// registered reports whether the client is still connected, the hub may have dropped it as slow.
+func (h *Hub) registered(client *Client) bool {
+	_, ok := h.clients[client.UserId][client]
+	return ok
+}
+
+// Publish implements events.Publisher, messages on topics other than nsi.<userId> are ignored.
+func (h *Hub) Publish(ctx context.Context, topic string, payload []byte) error {
+	userId, ok := events.ParseUserTopic(topic)
+	if !ok {
+		return nil
+	}
+
+	envelope := struct {
+		Id          string `json:"id"`
+		DashboardId *int   `json:"dashboardId"`
+	}{}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return err
+	}
+
+	var slow []*Client
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if envelope.DashboardId != nil {
+		h.events.append(*envelope.DashboardId, envelope.Id, userId, payload)
+	}
+
+	for client := range h.clients[userId] {
+		if client.dashboards != nil && envelope.DashboardId != nil && !client.dashboards[*envelope.DashboardId] {
+			continue
+		}
+
+		select {
+		case client.send <- payload:
+		default:
+			slow = append(slow, client)
+		}
+	}
+
+	for _, client := range slow {
+		h.log.Warn("dropping slow realtime client", slog.Int("userId", client.UserId))
+		h.unregister(client)
+	}
+
+	return nil
+}
+
+// Close disconnects every client.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, clients := range h.clients {
+		for client := range clients {
+			h.unregister(client)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package realtime
+
+import "time"
+
+const (
+	// logSize is how many events per dashboard stay available for Last-Event-ID resume.
+	logSize        = 256
+	logRetention   = 15 * time.Minute
+	logSweepPeriod = time.Minute
+)
+
+type logEntry struct {
+	id      string
+	at      time.Time
+	payload []byte
+	// audience holds the users the event was published to, as the dispatcher resolved them
+	audience map[int]bool
+}
+
+// eventLog keeps the latest events of each dashboard in memory, it is not synchronized and lives under the hub lock.
+type eventLog struct {
+	dashboards map[int][]logEntry
+	lastSweep  time.Time
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{dashboards: make(map[int][]logEntry)}
+}
+
+// append records the event once and userId as one of its recipients, the hub receives a copy per recipient.
+func (l *eventLog) append(dashboardId int, id string, userId int, payload []byte) {
+	now := time.Now()
+	l.sweep(now)
+
+	entries := l.dashboards[dashboardId]
+	for _, entry := range entries {
+		if entry.id == id {
+			entry.audience[userId] = true
+			return
+		}
+	}
+
+	entries = append(entries, logEntry{id, now, payload, map[int]bool{userId: true}})
+	if len(entries) > logSize {
+		entries = entries[len(entries)-logSize:]
+	}
+	l.dashboards[dashboardId] = entries
+}
+
+// since returns the events recorded after lastId that were published to userId, ok is false when lastId is no
+// longer retained.
+func (l *eventLog) since(dashboardId int, lastId string, userId int) ([][]byte, bool) {
+	entries := l.dashboards[dashboardId]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].id != lastId {
+			continue
+		}
+
+		result := make([][]byte, 0, len(entries)-i-1)
+		for _, entry := range entries[i+1:] {
+			if entry.audience[userId] {
+				result = append(result, entry.payload)
+			}
+		}
+		return result, true
+	}
+
+	return nil, false
+}
+
+// sweep forgets dashboards without recent activity so the log stays bounded by live dashboards.
+func (l *eventLog) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < logSweepPeriod {
+		return
+	}
+	l.lastSweep = now
+
+	for dashboardId, entries := range l.dashboards {
+		if now.Sub(entries[len(entries)-1].at) > logRetention {
+			delete(l.dashboards, dashboardId)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log/slog"
+	models "nsi/internal/domain"
+	"nsi/internal/events"
+	"slices"
+	"time"
+)
+
+const (
+	// presenceTimeout is how long a member stays listed without a heartbeat, pointer or selection update.
+	presenceTimeout    = 30 * time.Second
+	presenceSweepEvery = 5 * time.Second
+)
+
+// Join puts the client on the dashboard, or changes its state when it is already there. Like Register, the
+// caller checks rights first. Presence is tracked per instance and only reaches clients connected to it. A
+// client the hub already dropped is not put back.
+func (h *Hub) Join(client *Client, dashboardId int, state models.PresenceState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.registered(client) {
+		return
+	}
+
+	h.sweepPresence()
+
+	members := h.presence[dashboardId]
+	if members == nil {
+		members = make(map[*Client]*models.PresenceMember)
+		h.presence[dashboardId] = members
+	}
+
+	now := time.Now().UTC()
+	member, ok := members[client]
+	if !ok {
+		member = &models.PresenceMember{SessionId: client.Id, UserId: client.UserId, JoinedAt: now}
+		members[client] = member
+		client.joined[dashboardId] = true
+	}
+	member.State = state
+	member.LastSeen = now
+
+	h.broadcastPresence(client, dashboardId, events.PresenceJoin, member)
+}
+
+func (h *Hub) Leave(client *Client, dashboardId int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.leave(client, dashboardId)
+}
+
+func (h *Hub) Heartbeat(client *Client, dashboardId int) {
+	h.touch(client, dashboardId, events.PresenceHeartbeat, func(member *models.PresenceMember) {})
+}
+
+func (h *Hub) Point(client *Client, dashboardId int, pointer *models.Pointer) {
+	h.touch(client, dashboardId, events.PresencePointer, func(member *models.PresenceMember) {
+		member.Pointer = pointer
+	})
+}
+
+func (h *Hub) Select(client *Client, dashboardId int, selection []int) {
+	h.touch(client, dashboardId, events.PresenceSelection, func(member *models.PresenceMember) {
+		member.Selection = selection
+	})
+}
+
+// Presence lists who is on the dashboard, ordered by join time.
+func (h *Hub) Presence(dashboardId int) []models.PresenceMember {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sweepPresence()
+
+	result := make([]models.PresenceMember, 0, len(h.presence[dashboardId]))
+	for _, member := range h.presence[dashboardId] {
+		result = append(result, *member)
+	}
+	slices.SortFunc(result, func(a, b models.PresenceMember) int { return a.JoinedAt.Compare(b.JoinedAt) })
+
+	return result
+}
+
+// touch applies update to a joined client and broadcasts it, updates from clients that did not join are ignored.
+func (h *Hub) touch(client *Client, dashboardId int, eventType events.Type, update func(member *models.PresenceMember)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.registered(client) {
+		return
+	}
+
+	h.sweepPresence()
+
+	member, ok := h.presence[dashboardId][client]
+	if !ok {
+		return
+	}
+
+	update(member)
+	member.LastSeen = time.Now().UTC()
+
+	h.broadcastPresence(client, dashboardId, eventType, member)
+}
+
+func (h *Hub) leave(client *Client, dashboardId int) {
+	member, ok := h.forget(client, dashboardId)
+	if !ok {
+		return
+	}
+
+	h.broadcastPresence(client, dashboardId, events.PresenceLeave, member)
+}
+
+// forget takes the client off the dashboard without telling anyone.
+func (h *Hub) forget(client *Client, dashboardId int) (*models.PresenceMember, bool) {
+	member, ok := h.presence[dashboardId][client]
+	if !ok {
+		return nil, false
+	}
+
+	delete(h.presence[dashboardId], client)
+	if len(h.presence[dashboardId]) == 0 {
+		delete(h.presence, dashboardId)
+	}
+	delete(client.joined, dashboardId)
+
+	return member, true
+}
+
+// sweepPresence drops members that went quiet, at most once per presenceSweepEvery.
+func (h *Hub) sweepPresence() {
+	now := time.Now()
+	if now.Sub(h.lastPresenceSweep) < presenceSweepEvery {
+		return
+	}
+	h.lastPresenceSweep = now
+
+	for dashboardId, members := range h.presence {
+		for client, member := range members {
+			if now.Sub(member.LastSeen) > presenceTimeout {
+				h.leave(client, dashboardId)
+			}
+		}
+	}
+}
+
+// broadcastPresence sends the member's state to every other connection on the dashboard.
+func (h *Hub) broadcastPresence(from *Client, dashboardId int, eventType events.Type, member *models.PresenceMember) {
+	payload := events.PresencePayload{
+		SessionId: member.SessionId,
+		UserId:    member.UserId,
+		State:     member.State,
+		Pointer:   member.Pointer,
+		Selection: member.Selection,
+	}
+
+	envelope, err := events.New(eventType, member.UserId, &dashboardId, payload)
+	if err != nil {
+		h.log.Error(err.Error())
+		return
+	}
+
+	message, err := json.Marshal(envelope)
+	if err != nil {
+		h.log.Error(err.Error())
+		return
+	}
+
+	var slow []*Client
+	for client := range h.presence[dashboardId] {
+		if client == from {
+			continue
+		}
+
+		select {
+		case client.send <- message:
+		default:
+			slow = append(slow, client)
+		}
+	}
+
+	for _, client := range slow {
+		h.log.Warn("dropping slow realtime client", slog.Int("userId", client.UserId))
+		h.unregister(client)
+	}
+}
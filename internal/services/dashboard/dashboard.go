@@ -3,9 +3,12 @@ package dashboard
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	models "nsi/internal/domain"
+	document_models "nsi/internal/domain/document"
 	join_models "nsi/internal/domain/join"
+	"nsi/internal/events"
 	dashboardController "nsi/internal/http/dashboard"
 )
 
@@ -13,6 +16,7 @@ var (
 	ErrDashboardNotFound = errors.New("dashboard not found")
 	ErrDashboardInvalid  = errors.New("token invalid")
 	ErrorUpdateFailed    = errors.New("update token failed")
+	ErrDashboardCycle    = errors.New("dashboard cannot be moved into its own subtree")
 )
 
 type Service struct {
@@ -21,11 +25,17 @@ type Service struct {
 	dashboardProvider DashboardProvider
 	dashboardCreator  DashboardCreator
 	dashboardRemover  DashboardRemover
+	dashboardCopier   DashboardCopier
+	transactor        Transactor
+	history           History
+	events            events.Emitter
 }
 
 type DashboardProvider interface {
 	GetDashboard(ctx context.Context, model *models.Dashboard) error
 	GetDashboardsWithRights(ctx context.Context, userId int) ([]join_models.DashboardWithRight, error)
+	GetDashboardTree(ctx context.Context, userId int) ([]join_models.DashboardTreeNode, error)
+	DashboardNameExists(ctx context.Context, parentId *int, name string) (bool, error)
 }
 
 type DashboardCreator interface {
@@ -33,26 +43,59 @@ type DashboardCreator interface {
 }
 
 type DashboardUpdater interface {
+	UpdateDashboard(ctx context.Context, model *models.Dashboard) error
+	IsDashboardDescendant(ctx context.Context, ancestorId int, id int) (bool, error)
+	LockDashboard(ctx context.Context, id int) error
 }
 
 type DashboardRemover interface {
 	DeleteDashboard(ctx context.Context, id int) error
 }
 
-func New(log *slog.Logger, updater DashboardUpdater, provider DashboardProvider, creator DashboardCreator, remover DashboardRemover) *Service {
-	return &Service{log, updater, provider, creator, remover}
+// DashboardCopier reads and writes what clone, export and import carry besides the dashboards themselves.
+type DashboardCopier interface {
+	GetDashboardChildren(ctx context.Context, id int) ([]models.Dashboard, error)
+	GetAllWidgetsByDashboard(ctx context.Context, dashboardId int) (*[]join_models.WidgetWithRight, error)
+	GetWidgetsByDashboard(ctx context.Context, userId int, dashboardId int) (*[]join_models.WidgetWithRight, error)
+	CreateWidget(ctx context.Context, model *models.Widget) error
+}
+
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// History snapshots a dashboard as a new revision, called last in the transaction of the change it records.
+type History interface {
+	Record(ctx context.Context, dashboardId int, authorId int, change events.Type) error
+}
+
+// emitter should publish transactionally (through events.Outbox) so events are stored together with the change.
+func New(log *slog.Logger, updater DashboardUpdater, provider DashboardProvider, creator DashboardCreator, remover DashboardRemover, copier DashboardCopier, transactor Transactor, history History, emitter events.Emitter) *Service {
+	return &Service{log, updater, provider, creator, remover, copier, transactor, history, emitter}
 }
 
 func (service *Service) Create(ctx context.Context, name string, parentId *int, ownerId int, rightService dashboardController.RightHandler) (id int, err error) {
-	model := &models.Dashboard{Id: 0, Name: name, ParentId: parentId}
+	model := &models.Dashboard{Id: 0, Name: name, ParentId: parentId, Grid: models.DefaultGrid}
 
-	//todo: transaction
-	err = service.dashboardCreator.CreateDashboard(ctx, model)
-	if err != nil {
-		return 0, err
-	}
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.dashboardCreator.CreateDashboard(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		_, err = rightService.Create(ctx, &model.Id, nil, ownerId, models.Admin, ownerId)
+		if err != nil {
+			return err
+		}
 
-	_, err = rightService.Create(ctx, &model.Id, nil, ownerId, models.Admin)
+		payload := events.DashboardPayload{DashboardId: model.Id, Name: model.Name, ParentId: model.ParentId, Grid: events.GridOf(model.Grid)}
+		err = service.events.Emit(ctx, events.DashboardCreate, ownerId, &model.Id, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.Id, ownerId, events.DashboardCreate)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -80,10 +123,418 @@ func (service *Service) GetDashboardsWithAccess(ctx context.Context, userId int)
 	return result, nil
 }
 
-func (service *Service) Delete(ctx context.Context, id int) error {
-	return service.dashboardRemover.DeleteDashboard(ctx, id)
+func (service *Service) GetDashboardTree(ctx context.Context, userId int) ([]*join_models.DashboardTreeNode, error) {
+	nodes, err := service.dashboardProvider.GetDashboardTree(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[int]*join_models.DashboardTreeNode, len(nodes))
+	for i := range nodes {
+		byId[nodes[i].Id] = &nodes[i]
+	}
+
+	roots := make([]*join_models.DashboardTreeNode, 0)
+	for i := range nodes {
+		node := &nodes[i]
+		if node.ParentId != nil {
+			if parent, ok := byId[*node.ParentId]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	return roots, nil
+}
+
+func (service *Service) Delete(ctx context.Context, id int, actorId int) error {
+	return service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.writable(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// emitted first, the audience is resolved from rights that the delete cascades away
+		payload := events.DashboardDeletePayload{DashboardId: id}
+		err = service.events.Emit(ctx, events.DashboardDelete, actorId, &id, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.dashboardRemover.DeleteDashboard(ctx, id)
+	})
+}
+
+// Update applies dashboard when dashboard.Version is current or 0. On models.ErrVersionConflict the returned
+// dashboard is the current state, otherwise it is the updated one.
+func (service *Service) Update(ctx context.Context, id int, dashboard models.Dashboard, actorId int) (*models.Dashboard, error) {
+	dashboard.Id = id
+
+	if err := dashboard.Grid.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.writable(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if dashboard.ParentId != nil {
+			cycle, err := service.dashboardUpdater.IsDashboardDescendant(ctx, id, *dashboard.ParentId)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return ErrDashboardCycle
+			}
+		}
+
+		err = service.checkGrid(ctx, id, dashboard.Grid)
+		if err != nil {
+			return err
+		}
+
+		expected := dashboard.Version
+		err = service.dashboardUpdater.UpdateDashboard(ctx, &dashboard)
+		if err != nil {
+			if expected != 0 && service.dashboardProvider.GetDashboard(ctx, &dashboard) == nil {
+				return models.ErrVersionConflict
+			}
+			return err
+		}
+
+		payload := events.DashboardPayload{DashboardId: id, Name: dashboard.Name, ParentId: dashboard.ParentId, Grid: events.GridOf(dashboard.Grid)}
+		err = service.events.Emit(ctx, events.DashboardUpdate, actorId, &id, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, id, actorId, events.DashboardUpdate)
+	})
+
+	return &dashboard, err
+}
+
+// checkGrid makes sure the widgets of the dashboard still fit when its grid changes, with models.ErrInvalidLayout
+// for one outside the new grid and models.ErrLayoutOverlap for overlapping ones once collisions are checked. The
+// dashboard stays locked so no widget is placed against the old grid meanwhile.
+func (service *Service) checkGrid(ctx context.Context, id int, grid models.GridSettings) error {
+	err := service.dashboardUpdater.LockDashboard(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	current := &models.Dashboard{Id: id}
+	err = service.dashboardProvider.GetDashboard(ctx, current)
+	if err != nil {
+		return err
+	}
+	if current.Grid == grid {
+		return nil
+	}
+
+	widgets, err := service.dashboardCopier.GetAllWidgetsByDashboard(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for i, widget := range *widgets {
+		if _, err := grid.Fit(widget.Layout); err != nil {
+			return err
+		}
+
+		if !grid.Collision {
+			continue
+		}
+		for _, other := range (*widgets)[i+1:] {
+			if widget.Layout.Overlaps(other.Layout) {
+				return models.ErrLayoutOverlap
+			}
+		}
+	}
+
+	return nil
 }
 
-func (service *Service) Update(ctx context.Context, id int, dashboard models.Dashboard) error {
+// writable fails with models.ErrProvisioned for a provisioned dashboard, its definition file owns it.
+func (service *Service) writable(ctx context.Context, id int) error {
+	model := &models.Dashboard{Id: id}
+	err := service.dashboardProvider.GetDashboard(ctx, model)
+	if err != nil {
+		return err
+	}
+	if model.ProvisionKey != nil {
+		return models.ErrProvisioned
+	}
+
 	return nil
 }
+
+// Clone deep-copies the dashboard with its widgets under parentId and makes ownerId admin of the copy. With children
+// the whole subtree is copied, with rights the user and group grants of every copied dashboard and widget are too.
+func (service *Service) Clone(ctx context.Context, id int, name string, parentId *int, children bool, rights bool, ownerId int, rightService dashboardController.RightHandler) (cloneId int, err error) {
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		source := &models.Dashboard{Id: id}
+		err := service.dashboardProvider.GetDashboard(ctx, source)
+		if err != nil {
+			return err
+		}
+
+		// the subtree is read up front, a copy placed inside it must not be copied again
+		tree := map[int][]models.Dashboard{}
+		if children {
+			pending := []int{id}
+			for len(pending) > 0 {
+				current := pending[0]
+				pending = pending[1:]
+
+				tree[current], err = service.dashboardCopier.GetDashboardChildren(ctx, current)
+				if err != nil {
+					return err
+				}
+				for _, child := range tree[current] {
+					pending = append(pending, child.Id)
+				}
+			}
+		}
+
+		source.Name, source.ParentId = name, parentId
+		cloneId, err = service.clone(ctx, *source, tree, rights, ownerId, rightService)
+		if err != nil {
+			return err
+		}
+
+		_, err = rightService.Create(ctx, &cloneId, nil, ownerId, models.Admin, ownerId)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return cloneId, nil
+}
+
+// clone copies source and, recursively, its children listed in tree. source.Name and source.ParentId are those of
+// the copy. Only the widgets actorId holds a right on are copied, as GET /widgets lists them.
+func (service *Service) clone(ctx context.Context, source models.Dashboard, tree map[int][]models.Dashboard, rights bool, actorId int, rightService dashboardController.RightHandler) (int, error) {
+	model := &models.Dashboard{Name: source.Name, ParentId: source.ParentId, Grid: source.Grid}
+	err := service.dashboardCreator.CreateDashboard(ctx, model)
+	if err != nil {
+		return 0, err
+	}
+
+	payload := events.DashboardPayload{DashboardId: model.Id, Name: model.Name, ParentId: model.ParentId, Grid: events.GridOf(model.Grid)}
+	err = service.events.Emit(ctx, events.DashboardCreate, actorId, &model.Id, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if rights {
+		err = rightService.Copy(ctx, &source.Id, nil, &model.Id, nil, actorId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	widgets, err := service.dashboardCopier.GetWidgetsByDashboard(ctx, actorId, source.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, widget := range *widgets {
+		sourceId := widget.Id
+		copied := &models.Widget{Name: widget.Name, DashboardId: model.Id, WidgetType: widget.WidgetType, Config: widget.Config, Layout: widget.Layout}
+		err = service.dashboardCopier.CreateWidget(ctx, copied)
+		if err != nil {
+			return 0, err
+		}
+
+		// widgets are only listed to holders of a widget right, the cloning user gets one like on a new widget
+		_, err = rightService.Create(ctx, nil, &copied.Id, actorId, models.Admin, actorId)
+		if err != nil {
+			return 0, err
+		}
+
+		if rights {
+			err = rightService.Copy(ctx, nil, &sourceId, nil, &copied.Id, actorId)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		payload := events.WidgetCreateOf(*copied)
+		err = service.events.Emit(ctx, events.WidgetCreate, actorId, &model.Id, payload)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = service.history.Record(ctx, model.Id, actorId, events.DashboardCreate)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, child := range tree[source.Id] {
+		child.ParentId = &model.Id
+		_, err = service.clone(ctx, child, tree, rights, actorId, rightService)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return model.Id, nil
+}
+
+// Export serializes the dashboard and its widgets, with children its whole subtree too. With a viewerId only the
+// widgets that user holds a right on are included, as GET /widgets lists them.
+func (service *Service) Export(ctx context.Context, id int, children bool, viewerId *int) (*document_models.Document, error) {
+	model := &models.Dashboard{Id: id}
+	err := service.dashboardProvider.GetDashboard(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard, err := service.export(ctx, *model, children, viewerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &document_models.Document{Version: document_models.Version, Dashboard: *dashboard}, nil
+}
+
+func (service *Service) export(ctx context.Context, model models.Dashboard, children bool, viewerId *int) (*document_models.Dashboard, error) {
+	result := document_models.DashboardOf(model)
+
+	var widgets *[]join_models.WidgetWithRight
+	var err error
+	if viewerId != nil {
+		widgets, err = service.dashboardCopier.GetWidgetsByDashboard(ctx, *viewerId, model.Id)
+	} else {
+		widgets, err = service.dashboardCopier.GetAllWidgetsByDashboard(ctx, model.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, widget := range *widgets {
+		result.Widgets = append(result.Widgets, document_models.WidgetOf(widget.Widget))
+	}
+
+	if !children {
+		return &result, nil
+	}
+
+	nodes, err := service.dashboardCopier.GetDashboardChildren(ctx, model.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		child, err := service.export(ctx, node, children, viewerId)
+		if err != nil {
+			return nil, err
+		}
+		result.Children = append(result.Children, *child)
+	}
+
+	return &result, nil
+}
+
+// Import creates the document's dashboards under parentId with new ids and makes ownerId admin of the top one.
+// A dashboard named like the top one already being there is a conflict, reported with document_models.ErrImportConflict
+// unless rename is set, which then picks a free name.
+func (service *Service) Import(ctx context.Context, document document_models.Document, parentId *int, rename bool, ownerId int, rightService dashboardController.RightHandler) (*document_models.Report, error) {
+	if err := document.Validate(); err != nil {
+		return nil, err
+	}
+
+	report := &document_models.Report{Dashboards: map[int]int{}, Widgets: map[int]int{}}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		root := document.Dashboard
+		name := root.Name
+		for attempt := 2; ; attempt++ {
+			exists, err := service.dashboardProvider.DashboardNameExists(ctx, parentId, name)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				break
+			}
+			if !rename {
+				report.Conflicts = append(report.Conflicts, document_models.Problem{Path: "/dashboard/name", Message: "a dashboard with this name already exists"})
+				return document_models.ErrImportConflict
+			}
+			name = fmt.Sprintf("%v (%d)", root.Name, attempt)
+		}
+
+		root.Name = name
+		id, err := service.importDashboard(ctx, root, parentId, report, ownerId, rightService)
+		if err != nil {
+			return err
+		}
+
+		_, err = rightService.Create(ctx, &id, nil, ownerId, models.Admin, ownerId)
+		return err
+	})
+
+	return report, err
+}
+
+func (service *Service) importDashboard(ctx context.Context, dashboard document_models.Dashboard, parentId *int, report *document_models.Report, actorId int, rightService dashboardController.RightHandler) (int, error) {
+	model := &models.Dashboard{Name: dashboard.Name, ParentId: parentId, Grid: dashboard.Grid.Settings()}
+	err := service.dashboardCreator.CreateDashboard(ctx, model)
+	if err != nil {
+		return 0, err
+	}
+	report.Dashboards[dashboard.Id] = model.Id
+
+	payload := events.DashboardPayload{DashboardId: model.Id, Name: model.Name, ParentId: model.ParentId, Grid: events.GridOf(model.Grid)}
+	err = service.events.Emit(ctx, events.DashboardCreate, actorId, &model.Id, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, widget := range dashboard.Widgets {
+		// validated beforehand, Fit only snaps here
+		layout, err := model.Grid.Fit(widget.Layout.WidgetLayout())
+		if err != nil {
+			return 0, err
+		}
+
+		created := &models.Widget{Name: widget.Name, DashboardId: model.Id, WidgetType: widget.Type, Config: string(widget.Config), Layout: layout}
+		err = service.dashboardCopier.CreateWidget(ctx, created)
+		if err != nil {
+			return 0, err
+		}
+		report.Widgets[widget.Id] = created.Id
+
+		// widgets are only listed to holders of a widget right, the importing user gets one like on a new widget
+		_, err = rightService.Create(ctx, nil, &created.Id, actorId, models.Admin, actorId)
+		if err != nil {
+			return 0, err
+		}
+
+		err = service.events.Emit(ctx, events.WidgetCreate, actorId, &model.Id, events.WidgetCreateOf(*created))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = service.history.Record(ctx, model.Id, actorId, events.DashboardCreate)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, child := range dashboard.Children {
+		_, err = service.importDashboard(ctx, child, &model.Id, report, actorId, rightService)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return model.Id, nil
+}
@@ -0,0 +1,130 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	models "nsi/internal/domain"
+	"slices"
+)
+
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrNotGroupMember = errors.New("not a group member")
+	ErrNotGroupOwner  = errors.New("not a group owner")
+)
+
+type Service struct {
+	log           *slog.Logger
+	groupUpdater  GroupUpdater
+	groupProvider GroupProvider
+	groupCreator  GroupCreator
+	groupRemover  GroupRemover
+	transactor    Transactor
+}
+
+type GroupProvider interface {
+	GetGroup(ctx context.Context, model *models.UserGroup) error
+	GetUserGroups(ctx context.Context, userId int) ([]models.UserGroup, error)
+	GetGroupMembers(ctx context.Context, groupId int) ([]int, error)
+}
+
+type GroupCreator interface {
+	CreateGroup(ctx context.Context, model *models.UserGroup) error
+	AddGroupMember(ctx context.Context, groupId int, userId int) error
+}
+
+type GroupUpdater interface {
+	UpdateGroup(ctx context.Context, model *models.UserGroup) error
+}
+
+type GroupRemover interface {
+	DeleteGroup(ctx context.Context, id int) error
+	RemoveGroupMember(ctx context.Context, groupId int, userId int) error
+}
+
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+func New(log *slog.Logger, updater GroupUpdater, provider GroupProvider, creator GroupCreator, remover GroupRemover, transactor Transactor) *Service {
+	return &Service{log, updater, provider, creator, remover, transactor}
+}
+
+func (service *Service) Create(ctx context.Context, name string, ownerId int) (id int, err error) {
+	model := &models.UserGroup{Id: 0, Name: name, OwnerId: ownerId}
+
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.groupCreator.CreateGroup(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		return service.groupCreator.AddGroupMember(ctx, model.Id, ownerId)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return model.Id, nil
+}
+
+func (service *Service) GetGroup(ctx context.Context, id int) (*models.UserGroup, error) {
+	model := &models.UserGroup{Id: id}
+
+	err := service.groupProvider.GetGroup(ctx, model)
+	if err != nil {
+		return nil, ErrGroupNotFound
+	}
+
+	return model, nil
+}
+
+func (service *Service) GetGroups(ctx context.Context, userId int) ([]models.UserGroup, error) {
+	return service.groupProvider.GetUserGroups(ctx, userId)
+}
+
+func (service *Service) GetMembers(ctx context.Context, id int) ([]int, error) {
+	return service.groupProvider.GetGroupMembers(ctx, id)
+}
+
+// CheckGroup returns the group when userId owns it, or merely belongs to it if ownerOnly is false.
+func (service *Service) CheckGroup(ctx context.Context, userId int, id int, ownerOnly bool) (*models.UserGroup, error) {
+	model, err := service.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if model.OwnerId == userId {
+		return model, nil
+	}
+	if ownerOnly {
+		return nil, ErrNotGroupOwner
+	}
+
+	members, err := service.groupProvider.GetGroupMembers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(members, userId) {
+		return nil, ErrNotGroupMember
+	}
+
+	return model, nil
+}
+
+func (service *Service) Update(ctx context.Context, id int, name string) error {
+	return service.groupUpdater.UpdateGroup(ctx, &models.UserGroup{Id: id, Name: name})
+}
+
+func (service *Service) Delete(ctx context.Context, id int) error {
+	return service.groupRemover.DeleteGroup(ctx, id)
+}
+
+func (service *Service) AddMember(ctx context.Context, id int, userId int) error {
+	return service.groupCreator.AddGroupMember(ctx, id, userId)
+}
+
+func (service *Service) RemoveMember(ctx context.Context, id int, userId int) error {
+	return service.groupRemover.RemoveGroupMember(ctx, id, userId)
+}
@@ -0,0 +1,290 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	models "nsi/internal/domain"
+	document_models "nsi/internal/domain/document"
+	join_models "nsi/internal/domain/join"
+	"nsi/internal/events"
+	historyController "nsi/internal/http/history"
+)
+
+type Service struct {
+	log               *slog.Logger
+	revisionStore     RevisionStore
+	dashboardProvider DashboardProvider
+	widgetStore       WidgetStore
+	transactor        Transactor
+	events            events.Emitter
+}
+
+type RevisionStore interface {
+	CreateRevision(ctx context.Context, revision *models.Revision) error
+	GetRevisions(ctx context.Context, dashboardId int) ([]models.Revision, error)
+	GetRevision(ctx context.Context, revision *models.Revision) error
+}
+
+type DashboardProvider interface {
+	GetDashboard(ctx context.Context, model *models.Dashboard) error
+	UpdateDashboard(ctx context.Context, model *models.Dashboard) error
+	LockDashboard(ctx context.Context, id int) error
+}
+
+type WidgetStore interface {
+	GetAllWidgetsByDashboard(ctx context.Context, dashboardId int) (*[]join_models.WidgetWithRight, error)
+	GetWidgetsByDashboard(ctx context.Context, userId int, dashboardId int) (*[]join_models.WidgetWithRight, error)
+	CreateWidget(ctx context.Context, model *models.Widget) error
+	UpdateWidget(ctx context.Context, model *models.Widget) error
+	DeleteWidget(ctx context.Context, id int) error
+}
+
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// emitter should publish transactionally (through events.Outbox) so events are stored together with the change.
+func New(log *slog.Logger, revisionStore RevisionStore, dashboardProvider DashboardProvider, widgetStore WidgetStore, transactor Transactor, emitter events.Emitter) *Service {
+	return &Service{log, revisionStore, dashboardProvider, widgetStore, transactor, emitter}
+}
+
+// Record snapshots the dashboard and its widgets as they are in the transaction of ctx. It is called last in that
+// transaction, so revisions of a dashboard are numbered in the order their changes commit.
+func (service *Service) Record(ctx context.Context, dashboardId int, authorId int, change events.Type) error {
+	_, err := service.record(ctx, dashboardId, authorId, change)
+	return err
+}
+
+func (service *Service) record(ctx context.Context, dashboardId int, authorId int, change events.Type) (*models.Revision, error) {
+	dashboard := &models.Dashboard{Id: dashboardId}
+	err := service.dashboardProvider.GetDashboard(ctx, dashboard)
+	if err != nil {
+		return nil, err
+	}
+
+	widgets, err := service.widgetStore.GetAllWidgetsByDashboard(ctx, dashboardId)
+	if err != nil {
+		return nil, err
+	}
+
+	document := document_models.Document{Version: document_models.Version, Dashboard: document_models.DashboardOf(*dashboard)}
+	for _, widget := range *widgets {
+		document.Dashboard.Widgets = append(document.Dashboard.Widgets, document_models.WidgetOf(widget.Widget))
+	}
+
+	snapshot, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &models.Revision{DashboardId: dashboardId, AuthorId: authorId, Change: string(change), Snapshot: snapshot}
+	err = service.revisionStore.CreateRevision(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// GetRevisions lists the revisions of the dashboard newest first, without snapshots.
+func (service *Service) GetRevisions(ctx context.Context, dashboardId int) ([]models.Revision, error) {
+	return service.revisionStore.GetRevisions(ctx, dashboardId)
+}
+
+// GetRevision loads the revision with its snapshot. With a viewerId the snapshot only keeps the widgets that user
+// holds a right on now, as GET /widgets lists them, so widgets deleted since are left out as well.
+func (service *Service) GetRevision(ctx context.Context, dashboardId int, version int, viewerId *int) (*models.Revision, error) {
+	revision := &models.Revision{DashboardId: dashboardId, Version: version}
+
+	err := service.revisionStore.GetRevision(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	if viewerId == nil {
+		return revision, nil
+	}
+
+	visible, err := service.widgetStore.GetWidgetsByDashboard(ctx, *viewerId, dashboardId)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]bool, len(*visible))
+	for _, widget := range *visible {
+		ids[widget.Id] = true
+	}
+
+	var document document_models.Document
+	err = json.Unmarshal(revision.Snapshot, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	widgets := make([]document_models.Widget, 0, len(document.Dashboard.Widgets))
+	for _, widget := range document.Dashboard.Widgets {
+		if ids[widget.Id] {
+			widgets = append(widgets, widget)
+		}
+	}
+	document.Dashboard.Widgets = widgets
+
+	revision.Snapshot, err = json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// Restore brings the dashboard back to revision version and records that as a new revision, which it returns.
+// Name and grid are restored, the parent stays. Widgets still on the dashboard keep their id and rights, deleted
+// ones are created again under a new id, with actorId as their admin instead of the rights they had. A snapshot
+// that no longer passes validation, e.g. after a widget type schema changed, fails with *document_models.InvalidError.
+func (service *Service) Restore(ctx context.Context, dashboardId int, version int, actorId int, rightService historyController.RightHandler) (*models.Revision, error) {
+	var result *models.Revision
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.dashboardProvider.LockDashboard(ctx, dashboardId)
+		if err != nil {
+			return err
+		}
+
+		dashboard := &models.Dashboard{Id: dashboardId}
+		err = service.dashboardProvider.GetDashboard(ctx, dashboard)
+		if err != nil {
+			return err
+		}
+		if dashboard.ProvisionKey != nil {
+			return models.ErrProvisioned
+		}
+
+		revision := &models.Revision{DashboardId: dashboardId, Version: version}
+		err = service.revisionStore.GetRevision(ctx, revision)
+		if err != nil {
+			return err
+		}
+
+		var document document_models.Document
+		err = json.Unmarshal(revision.Snapshot, &document)
+		if err != nil {
+			return err
+		}
+
+		err = document.Validate()
+		if err != nil {
+			return err
+		}
+		snapshot := document.Dashboard
+
+		dashboard.Name, dashboard.Grid, dashboard.Version = snapshot.Name, snapshot.Grid.Settings(), 0
+		err = service.dashboardProvider.UpdateDashboard(ctx, dashboard)
+		if err != nil {
+			return err
+		}
+
+		payload := events.DashboardPayload{DashboardId: dashboardId, Name: dashboard.Name, ParentId: dashboard.ParentId, Grid: events.GridOf(dashboard.Grid)}
+		err = service.events.Emit(ctx, events.DashboardUpdate, actorId, &dashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		err = service.restoreWidgets(ctx, dashboardId, snapshot.Widgets, actorId, rightService)
+		if err != nil {
+			return err
+		}
+
+		result, err = service.record(ctx, dashboardId, actorId, events.DashboardRestore)
+		if err != nil {
+			return err
+		}
+
+		restorePayload := events.DashboardRestorePayload{DashboardId: dashboardId, Version: version, Revision: result.Version}
+		return service.events.Emit(ctx, events.DashboardRestore, actorId, &dashboardId, restorePayload)
+	})
+
+	return result, err
+}
+
+// restoreWidgets makes the widgets of the dashboard match snapshot, touching only those that differ.
+func (service *Service) restoreWidgets(ctx context.Context, dashboardId int, snapshot []document_models.Widget, actorId int, rightService historyController.RightHandler) error {
+	widgets, err := service.widgetStore.GetAllWidgetsByDashboard(ctx, dashboardId)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[int]models.Widget, len(*widgets))
+	for _, widget := range *widgets {
+		current[widget.Id] = widget.Widget
+	}
+
+	for _, widget := range snapshot {
+		restored := &models.Widget{
+			Id:          widget.Id,
+			Name:        widget.Name,
+			DashboardId: dashboardId,
+			WidgetType:  widget.Type,
+			Config:      string(widget.Config),
+			Layout:      widget.Layout.WidgetLayout(),
+		}
+
+		existing, ok := current[widget.Id]
+		delete(current, widget.Id)
+
+		if !ok {
+			err = service.widgetStore.CreateWidget(ctx, restored)
+			if err != nil {
+				return err
+			}
+
+			// widgets are only listed to holders of a widget right, the restoring user gets one like on a new widget
+			_, err = rightService.Create(ctx, nil, &restored.Id, actorId, models.Admin, actorId)
+			if err != nil {
+				return err
+			}
+
+			err = service.events.Emit(ctx, events.WidgetCreate, actorId, &dashboardId, events.WidgetCreateOf(*restored))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(existing.Differences(*restored)) == 0 {
+			continue
+		}
+
+		err = service.widgetStore.UpdateWidget(ctx, restored)
+		if err != nil {
+			return err
+		}
+
+		payload := events.WidgetConfigPayload{WidgetId: restored.Id, Config: json.RawMessage(restored.Config), Version: restored.Version}
+		err = service.events.Emit(ctx, events.WidgetUpdateConfig, actorId, &dashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		layoutPayload := events.WidgetLayoutPayload{WidgetId: restored.Id, Layout: events.LayoutOf(restored.Layout), Version: restored.Version}
+		err = service.events.Emit(ctx, events.WidgetUpdatePos, actorId, &dashboardId, layoutPayload)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, widget := range current {
+		// emitted first, the audience is resolved from rights that the delete cascades away
+		err = service.events.Emit(ctx, events.WidgetDelete, actorId, &dashboardId, events.WidgetDeletePayload{WidgetId: widget.Id})
+		if err != nil {
+			return err
+		}
+
+		err = service.widgetStore.DeleteWidget(ctx, widget.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,23 +2,33 @@ package rights
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"log/slog"
 	models "nsi/internal/domain"
+	"nsi/internal/events"
+	"time"
 )
 
 var (
 	ErrRightNotFound   = errors.New("Right not found")
 	ErrNotEnoughRights = errors.New("Not enough rights")
 	ErrRightExists     = errors.New("Right exists")
+	ErrTokenExpired    = errors.New("Token expiry is in the past")
+	ErrGroupNotFound   = errors.New("Group not found")
 )
 
+const shareTokenBytes = 32
+
 type Service struct {
 	log            *slog.Logger
 	rightsUpdater  RightsUpdater
 	rightsProvider RightsProvider
 	rightsRemover  RightsRemover
 	rightsCreator  RightsCreator
+	transactor     Transactor
+	events         events.Emitter
 }
 
 type RightsCreator interface {
@@ -40,6 +50,11 @@ type RightsProvider interface {
 	GetWidgetRights(ctx context.Context, widgetdId int) ([]models.AccessRight, error)
 
 	GetAccessRightByData(ctx context.Context, userId int, id int) (*models.AccessRight, error)
+	GetAccessRightDashboard(ctx context.Context, id int) (int, error)
+
+	GetDashboardRightByToken(ctx context.Context, token string, dashboardId int) (*models.AccessRight, error)
+	GetGroup(ctx context.Context, model *models.UserGroup) error
+	GetWidgetRightByToken(ctx context.Context, token string, widgetId int) (*models.AccessRight, error)
 }
 
 type RightsUpdater interface {
@@ -47,8 +62,13 @@ type RightsUpdater interface {
 	UpdateAccessRightType(ctx context.Context, id int, grant models.GrantType) error
 }
 
-func New(log *slog.Logger, updater RightsUpdater, provider RightsProvider, remover RightsRemover, creator RightsCreator) *Service {
-	return &Service{log, updater, provider, remover, creator}
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// emitter should publish transactionally (through events.Outbox) so events are stored together with the change.
+func New(log *slog.Logger, updater RightsUpdater, provider RightsProvider, remover RightsRemover, creator RightsCreator, transactor Transactor, emitter events.Emitter) *Service {
+	return &Service{log, updater, provider, remover, creator, transactor, emitter}
 }
 
 func (service *Service) CheckDashboardRight(ctx context.Context, userId int, dashboardId int, rightType models.GrantType) (right *models.AccessRight, err error) {
@@ -73,6 +93,20 @@ func (service *Service) checkRight(ctx context.Context, userId int, rightType mo
 		right, err = service.rightsProvider.GetAccessRightByData(ctx, userId, *accessId)
 	}
 
+	return compareRight(right, err, rightType)
+}
+
+func (service *Service) CheckDashboardTokenRight(ctx context.Context, token string, dashboardId int, rightType models.GrantType) (*models.AccessRight, error) {
+	right, err := service.rightsProvider.GetDashboardRightByToken(ctx, token, dashboardId)
+	return compareRight(right, err, rightType)
+}
+
+func (service *Service) CheckWidgetTokenRight(ctx context.Context, token string, widgetId int, rightType models.GrantType) (*models.AccessRight, error) {
+	right, err := service.rightsProvider.GetWidgetRightByToken(ctx, token, widgetId)
+	return compareRight(right, err, rightType)
+}
+
+func compareRight(right *models.AccessRight, err error, rightType models.GrantType) (*models.AccessRight, error) {
 	if err != nil || right == nil {
 		return nil, ErrRightNotFound
 	}
@@ -84,45 +118,229 @@ func (service *Service) checkRight(ctx context.Context, userId int, rightType mo
 	return nil, ErrNotEnoughRights
 }
 
-func (service *Service) Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType) (id int, err error) {
+func (service *Service) Create(ctx context.Context, dashboardId *int, widgetdId *int, userId int, grantType models.GrantType, actorId int) (id int, err error) {
 	access := models.AccessRight{
 		Id:     0,
 		UserId: &userId,
 		Type:   grantType,
 	}
 
-	//todo : transaction
 	_, err = service.checkRight(ctx, userId, grantType, dashboardId, widgetdId, nil)
 
-	err = service.rightsCreator.CreateAccessRight(ctx, &access)
-	if err != nil {
-		return 0, err
-	}
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		id, err = service.create(ctx, &access, dashboardId, widgetdId)
+		if err != nil {
+			return err
+		}
 
-	id = access.Id
+		target, err := service.eventDashboard(ctx, id)
+		if err != nil {
+			return err
+		}
 
-	if dashboardId != nil {
-		id, err = service.rightsCreator.CreateDashboardAccessRight(ctx, *dashboardId, access.Id)
-	} else if widgetdId != nil {
-		id, err = service.rightsCreator.CreateWidgetAccessRight(ctx, *widgetdId, access.Id)
+		payload := events.RightsPayload{RightId: id, UserId: &userId, DashboardId: dashboardId, WidgetId: widgetdId, Grant: grantType}
+		return service.events.Emit(ctx, events.RightsCreate, actorId, target, payload, userId)
+	})
+
+	return id, err
+}
+
+// CreateForGroup fails with ErrGroupNotFound for an unknown group. The event reaches the members through the
+// dashboard audience, which resolves groups.
+func (service *Service) CreateForGroup(ctx context.Context, dashboardId *int, widgetdId *int, groupId int, grantType models.GrantType, actorId int) (id int, err error) {
+	access := models.AccessRight{
+		Id:          0,
+		UserGroupId: &groupId,
+		Type:        grantType,
 	}
 
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		if service.rightsProvider.GetGroup(ctx, &models.UserGroup{Id: groupId}) != nil {
+			return ErrGroupNotFound
+		}
+
+		id, err = service.create(ctx, &access, dashboardId, widgetdId)
+		if err != nil {
+			return err
+		}
+
+		target, err := service.eventDashboard(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		payload := events.RightsPayload{RightId: id, GroupId: &groupId, DashboardId: dashboardId, WidgetId: widgetdId, Grant: grantType}
+		return service.events.Emit(ctx, events.RightsCreate, actorId, target, payload)
+	})
+
 	return id, err
 }
 
-func (service *Service) Delete(ctx context.Context, dashboardId *int, widgetdId *int, rightId int) error {
-	var err error
-	if dashboardId != nil {
-		err = service.rightsRemover.DeleteDashboardAccessRight(ctx, *dashboardId, rightId)
-	} else if widgetdId != nil {
-		err = service.rightsRemover.DeleteWidgetAccessRight(ctx, *widgetdId, rightId)
+// CreateShareToken mints a random token granting grantType on the dashboard subtree to whoever presents it.
+func (service *Service) CreateShareToken(ctx context.Context, dashboardId int, grantType models.GrantType, expiresAt *time.Time) (*models.AccessRight, error) {
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		return nil, ErrTokenExpired
 	}
 
-	return err
+	raw := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(raw)
+
+	access := models.AccessRight{
+		Id:          0,
+		AccessToken: &token,
+		ExpiresAt:   expiresAt,
+		Type:        grantType,
+	}
+
+	_, err := service.create(ctx, &access, &dashboardId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &access, nil
+}
+
+func (service *Service) create(ctx context.Context, access *models.AccessRight, dashboardId *int, widgetdId *int) (id int, err error) {
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.rightsCreator.CreateAccessRight(ctx, access)
+		if err != nil {
+			return err
+		}
+
+		id = access.Id
+
+		if dashboardId != nil {
+			id, err = service.rightsCreator.CreateDashboardAccessRight(ctx, *dashboardId, access.Id)
+		} else if widgetdId != nil {
+			id, err = service.rightsCreator.CreateWidgetAccessRight(ctx, *widgetdId, access.Id)
+		}
+
+		return err
+	})
+
+	return id, err
+}
+
+func (service *Service) Delete(ctx context.Context, dashboardId *int, widgetdId *int, rightId int, actorId int) error {
+	return service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		var rights []models.AccessRight
+		var err error
+		if dashboardId != nil {
+			rights, err = service.rightsProvider.GetDashboardRights(ctx, *dashboardId)
+		} else if widgetdId != nil {
+			rights, err = service.rightsProvider.GetWidgetRights(ctx, *widgetdId)
+		}
+		if err != nil {
+			return err
+		}
+
+		// the event goes out before the delete so the audience still contains whoever loses access
+		var users []int
+		payload := events.RightsPayload{RightId: rightId, DashboardId: dashboardId, WidgetId: widgetdId}
+		for _, right := range rights {
+			if right.Id == rightId {
+				payload.UserId, payload.GroupId, payload.Grant = right.UserId, right.UserGroupId, right.Type
+				if right.UserId != nil {
+					users = append(users, *right.UserId)
+				}
+			}
+		}
+
+		target, err := service.eventDashboard(ctx, rightId)
+		if err != nil {
+			return err
+		}
+
+		err = service.events.Emit(ctx, events.RightsDelete, actorId, target, payload, users...)
+		if err != nil {
+			return err
+		}
+
+		if dashboardId != nil {
+			err = service.rightsRemover.DeleteDashboardAccessRight(ctx, *dashboardId, rightId)
+		} else if widgetdId != nil {
+			err = service.rightsRemover.DeleteWidgetAccessRight(ctx, *widgetdId, rightId)
+		}
+
+		return err
+	})
 }
 
-func (service *Service) Update(ctx context.Context, userId int, id int, grant models.GrantType) (int, error) {
-	return id, service.rightsUpdater.UpdateAccessRightType(ctx, id, grant)
+func (service *Service) Update(ctx context.Context, userId int, id int, grant models.GrantType, actorId int) (int, error) {
+	return id, service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.rightsUpdater.UpdateAccessRightType(ctx, id, grant)
+		if err != nil {
+			return err
+		}
+
+		target, err := service.eventDashboard(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		payload := events.RightsPayload{RightId: id, UserId: &userId, Grant: grant}
+		return service.events.Emit(ctx, events.RightsUpdate, actorId, target, payload, userId)
+	})
+}
+
+// Copy grants the user and group rights of the source dashboard or widget on the target one. Share tokens are left
+// out, a link shared for the source must not open the copy.
+func (service *Service) Copy(ctx context.Context, fromDashboardId *int, fromWidgetId *int, toDashboardId *int, toWidgetId *int, actorId int) error {
+	return service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		var rights []models.AccessRight
+		var err error
+		if fromDashboardId != nil {
+			rights, err = service.rightsProvider.GetDashboardRights(ctx, *fromDashboardId)
+		} else if fromWidgetId != nil {
+			rights, err = service.rightsProvider.GetWidgetRights(ctx, *fromWidgetId)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, right := range rights {
+			if right.AccessToken != nil {
+				continue
+			}
+
+			right.Id = 0
+			id, err := service.create(ctx, &right, toDashboardId, toWidgetId)
+			if err != nil {
+				return err
+			}
+
+			target, err := service.eventDashboard(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			var users []int
+			if right.UserId != nil {
+				users = append(users, *right.UserId)
+			}
+
+			payload := events.RightsPayload{RightId: id, UserId: right.UserId, GroupId: right.UserGroupId, DashboardId: toDashboardId, WidgetId: toWidgetId, Grant: right.Type}
+			err = service.events.Emit(ctx, events.RightsCreate, actorId, target, payload, users...)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// eventDashboard is the dashboard events about a right are published for, widget rights belong to the widget's dashboard.
+func (service *Service) eventDashboard(ctx context.Context, rightId int) (*int, error) {
+	dashboardId, err := service.rightsProvider.GetAccessRightDashboard(ctx, rightId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dashboardId, nil
 }
 
 func (service *Service) GetRights(ctx context.Context, id int, isDasboard bool) ([]models.AccessRight, error) {
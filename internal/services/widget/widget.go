@@ -2,23 +2,41 @@ package widget
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	models "nsi/internal/domain"
 	join_models "nsi/internal/domain/join"
+	"nsi/internal/events"
 	widgetController "nsi/internal/http/widget"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
 )
 
+// patchAttempts bounds how often a patch is re-applied when another write lands between reading and storing the config.
+const patchAttempts = 3
+
+var errConfigChanged = errors.New("config changed while patching")
+
 type Service struct {
 	log            *slog.Logger
 	widgetUpdater  WidgetUpdater
 	widgetProvider WidgetProvider
 	widgetRemover  WidgetRemover
 	widgetCreator  WidgetCreator
+	transactor     Transactor
+	history        History
+	events         events.Emitter
 }
 
 type WidgetProvider interface {
+	GetWidget(ctx context.Context, model *models.Widget) error
 	GetWidgetsByDashboard(ctx context.Context, userId int, dashboardId int) (*[]join_models.WidgetWithRight, error)
 	GetAllWidgetsByDashboard(ctx context.Context, dashboardId int) (*[]join_models.WidgetWithRight, error)
+
+	GetDashboard(ctx context.Context, model *models.Dashboard) error
+	LockDashboard(ctx context.Context, id int) error
 }
 
 type WidgetRemover interface {
@@ -30,23 +48,61 @@ type WidgetCreator interface {
 }
 
 type WidgetUpdater interface {
-	UpdatePosition(id int, x, y float64) error
-	UpdateConfig(id int, config string) error
+	UpdateLayout(ctx context.Context, id int, layout models.WidgetLayout, version int) (int, error)
+	UpdateConfig(ctx context.Context, id int, config string, version int) (int, error)
+	MoveWidget(ctx context.Context, id int, dashboardId int, layout models.WidgetLayout) (int, error)
 }
 
-func New(log *slog.Logger, updater WidgetUpdater, provider WidgetProvider, widgetRemover WidgetRemover, widgetCreator WidgetCreator) *Service {
-	return &Service{log, updater, provider, widgetRemover, widgetCreator}
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
-func (service *Service) Create(ctx context.Context, name string, dashboardId int, widgetType models.WidgetType, config string, ownerId int, rightService widgetController.RightHandler) (id int, err error) {
+// History snapshots a dashboard as a new revision, called last in the transaction of the change it records.
+type History interface {
+	Record(ctx context.Context, dashboardId int, authorId int, change events.Type) error
+}
+
+// emitter should publish transactionally (through events.Outbox) so events are stored together with the change.
+func New(log *slog.Logger, updater WidgetUpdater, provider WidgetProvider, widgetRemover WidgetRemover, widgetCreator WidgetCreator, transactor Transactor, history History, emitter events.Emitter) *Service {
+	return &Service{log, updater, provider, widgetRemover, widgetCreator, transactor, history, emitter}
+}
+
+// Create places the widget at layout, or below the existing widgets with the default size when layout is nil.
+func (service *Service) Create(ctx context.Context, name string, dashboardId int, widgetType models.WidgetType, config string, layout *models.WidgetLayout, ownerId int, rightService widgetController.RightHandler) (id int, err error) {
+	if config == "" {
+		config = widgetType.DefaultConfig()
+	}
+
 	model := &models.Widget{Id: 0, Name: name, DashboardId: dashboardId, WidgetType: widgetType, Config: config}
 
-	err = service.widgetCreator.CreateWidget(ctx, model)
-	if err != nil {
+	if err := widgetType.ValidateConfig(config); err != nil {
 		return 0, err
 	}
 
-	_, err = rightService.Create(ctx, nil, &model.Id, ownerId, models.Admin)
+	err = service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.place(ctx, model, layout)
+		if err != nil {
+			return err
+		}
+
+		err = service.widgetCreator.CreateWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		_, err = rightService.Create(ctx, nil, &model.Id, ownerId, models.Admin, ownerId)
+		if err != nil {
+			return err
+		}
+
+		payload := events.WidgetCreateOf(*model)
+		err = service.events.Emit(ctx, events.WidgetCreate, ownerId, &model.DashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.DashboardId, ownerId, events.WidgetCreate)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -54,27 +110,497 @@ func (service *Service) Create(ctx context.Context, name string, dashboardId int
 	return model.Id, nil
 }
 
-func (service *Service) Delete(ctx context.Context, id int) error {
-	return service.widgetRemover.DeleteWidget(ctx, id)
+func (service *Service) Delete(ctx context.Context, id int, actorId int) error {
+	return service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		model := &models.Widget{Id: id}
+		err := service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		err = service.writable(ctx, model.DashboardId)
+		if err != nil {
+			return err
+		}
+
+		// emitted first, the audience is resolved from rights that the delete cascades away
+		payload := events.WidgetDeletePayload{WidgetId: id}
+		err = service.events.Emit(ctx, events.WidgetDelete, actorId, &model.DashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		err = service.widgetRemover.DeleteWidget(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.DashboardId, actorId, events.WidgetDelete)
+	})
 }
 
-func (service *Service) UpdatePos(ctx context.Context, id int, x, y float64) error {
-	err := service.widgetUpdater.UpdatePosition(id, x, y)
+func (service *Service) GetWidget(ctx context.Context, id int) (*models.Widget, error) {
+	model := &models.Widget{Id: id}
+
+	err := service.widgetProvider.GetWidget(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
+
+// Copy pastes a copy of the widget on dashboardId at layout, or below its widgets when layout is nil. actorId is
+// made admin of the copy like of a new widget, and the widget rights are granted on the copy as well.
+func (service *Service) Copy(ctx context.Context, id int, dashboardId int, layout *models.WidgetLayout, actorId int, rightService widgetController.RightHandler) (*models.Widget, error) {
+	source := &models.Widget{Id: id}
+	model := &models.Widget{}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.widgetProvider.GetWidget(ctx, source)
+		if err != nil {
+			return err
+		}
+
+		*model = models.Widget{Name: source.Name, DashboardId: dashboardId, WidgetType: source.WidgetType, Config: source.Config}
+		err = service.place(ctx, model, layout)
+		if err != nil {
+			return err
+		}
+
+		err = service.widgetCreator.CreateWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		// granted first, the rights of the copy make up the audience of its event
+		_, err = rightService.Create(ctx, nil, &model.Id, actorId, models.Admin, actorId)
+		if err != nil {
+			return err
+		}
+
+		err = rightService.Copy(ctx, nil, &source.Id, nil, &model.Id, actorId)
+		if err != nil {
+			return err
+		}
+
+		err = service.events.Emit(ctx, events.WidgetCreate, actorId, &model.DashboardId, events.WidgetCreateOf(*model))
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.DashboardId, actorId, events.WidgetCreate)
+	})
+
+	return model, err
+}
+
+// Move puts the widget on dashboardId at layout, or below its widgets when layout is nil. The widget keeps its id
+// and rights, subscribers of the source dashboard see it deleted and those of the target see it created.
+func (service *Service) Move(ctx context.Context, id int, dashboardId int, layout *models.WidgetLayout, actorId int) (*models.Widget, error) {
+	model := &models.Widget{Id: id}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		source := model.DashboardId
+		err = service.writable(ctx, source)
+		if err != nil {
+			return err
+		}
+
+		err = service.events.Emit(ctx, events.WidgetDelete, actorId, &source, events.WidgetDeletePayload{WidgetId: id})
+		if err != nil {
+			return err
+		}
+
+		model.DashboardId = dashboardId
+		err = service.place(ctx, model, layout)
+		if err != nil {
+			return err
+		}
+
+		model.Version, err = service.widgetUpdater.MoveWidget(ctx, id, dashboardId, model.Layout)
+		if err != nil {
+			return err
+		}
+
+		err = service.events.Emit(ctx, events.WidgetCreate, actorId, &model.DashboardId, events.WidgetCreateOf(*model))
+		if err != nil {
+			return err
+		}
+
+		// lower dashboard first, revision counters are locked in the same order by every transaction
+		changes := map[int]events.Type{source: events.WidgetDelete, dashboardId: events.WidgetCreate}
+		for _, id := range []int{min(source, dashboardId), max(source, dashboardId)} {
+			err = service.history.Record(ctx, id, actorId, changes[id])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return model, err
+}
+
+// UpdateLayout and UpdateConfig apply when version is current or 0. On models.ErrVersionConflict the returned
+// widget is the current state, otherwise it is the updated one.
+func (service *Service) UpdateLayout(ctx context.Context, id int, change models.LayoutChange, version int, actorId int) (*models.Widget, error) {
+	model := &models.Widget{Id: id}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		layout := change.Apply(model.Layout)
+		if model.Layout.Locked && layout.Locked && !layout.SameArea(model.Layout) {
+			return models.ErrWidgetLocked
+		}
+
+		err = service.place(ctx, model, &layout)
+		if err != nil {
+			return err
+		}
+
+		_, err = service.widgetUpdater.UpdateLayout(ctx, id, model.Layout, version)
+		if err != nil {
+			return service.conflict(ctx, model, version, err)
+		}
+
+		err = service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		payload := events.WidgetLayoutPayload{WidgetId: id, Layout: events.LayoutOf(model.Layout), Version: model.Version}
+		err = service.events.Emit(ctx, events.WidgetUpdatePos, actorId, &model.DashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.DashboardId, actorId, events.WidgetUpdatePos)
+	})
+
+	return model, err
+}
+
+// place fits layout into the grid of model's dashboard and, when the grid checks collisions, makes sure it
+// does not overlap another widget. A nil layout is placed below everything else. The dashboard stays locked
+// until the transaction ends so concurrent placements cannot both pass the check.
+func (service *Service) place(ctx context.Context, model *models.Widget, layout *models.WidgetLayout) error {
+	err := service.widgetProvider.LockDashboard(ctx, model.DashboardId)
+	if err != nil {
+		return err
+	}
+
+	dashboard := &models.Dashboard{Id: model.DashboardId}
+	err = service.widgetProvider.GetDashboard(ctx, dashboard)
+	if err != nil {
+		return err
+	}
+	if dashboard.ProvisionKey != nil {
+		return models.ErrProvisioned
+	}
+
+	others, err := service.widgetProvider.GetAllWidgetsByDashboard(ctx, model.DashboardId)
 	if err != nil {
 		return err
 	}
+
+	if layout == nil {
+		layout = &models.WidgetLayout{W: min(models.DefaultWidgetWidth, float64(dashboard.Grid.Columns)), H: models.DefaultWidgetHeight}
+		for _, other := range *others {
+			layout.Y = max(layout.Y, other.Layout.Y+other.Layout.H)
+			layout.Z = max(layout.Z, other.Layout.Z+1)
+		}
+	}
+
+	fitted, err := dashboard.Grid.Fit(*layout)
+	if err != nil {
+		return err
+	}
+
+	if dashboard.Grid.Collision {
+		for _, other := range *others {
+			if other.Id != model.Id && fitted.Overlaps(other.Layout) {
+				return models.ErrLayoutOverlap
+			}
+		}
+	}
+
+	model.Layout = fitted
 	return nil
 }
 
-func (service *Service) UpdateConfig(ctx context.Context, id int, config string) error {
-	err := service.widgetUpdater.UpdateConfig(id, config)
+// UpdateDashboardLayout moves several widgets of a dashboard in one transaction. Collisions are checked against
+// the final arrangement, so widgets may swap places. On models.ErrVersionConflict the returned widgets are the
+// current state of the requested ones, otherwise they are the updated ones.
+func (service *Service) UpdateDashboardLayout(ctx context.Context, dashboardId int, updates []models.WidgetLayoutUpdate, actorId int) ([]models.Widget, error) {
+	result := make([]models.Widget, 0, len(updates))
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.widgetProvider.LockDashboard(ctx, dashboardId)
+		if err != nil {
+			return err
+		}
+
+		dashboard := &models.Dashboard{Id: dashboardId}
+		err = service.widgetProvider.GetDashboard(ctx, dashboard)
+		if err != nil {
+			return err
+		}
+		if dashboard.ProvisionKey != nil {
+			return models.ErrProvisioned
+		}
+
+		all, err := service.widgetProvider.GetAllWidgetsByDashboard(ctx, dashboardId)
+		if err != nil {
+			return err
+		}
+
+		widgets := make(map[int]*models.Widget, len(*all))
+		for i := range *all {
+			widgets[(*all)[i].Id] = &(*all)[i].Widget
+		}
+
+		conflict := false
+		for _, update := range updates {
+			model, ok := widgets[update.WidgetId]
+			if !ok {
+				return fmt.Errorf("%w: widget %d is not on dashboard %d", models.ErrInvalidLayout, update.WidgetId, dashboardId)
+			}
+			if update.Version != 0 && update.Version != model.Version {
+				conflict = true
+			}
+			result = append(result, *model)
+		}
+		if conflict {
+			return models.ErrVersionConflict
+		}
+
+		changed := make(map[int]bool, len(updates))
+		for _, update := range updates {
+			if changed[update.WidgetId] {
+				return fmt.Errorf("%w: widget %d is listed twice", models.ErrInvalidLayout, update.WidgetId)
+			}
+			changed[update.WidgetId] = true
+
+			model := widgets[update.WidgetId]
+			layout := update.Apply(model.Layout)
+			if model.Layout.Locked && layout.Locked && !layout.SameArea(model.Layout) {
+				return models.ErrWidgetLocked
+			}
+
+			model.Layout, err = dashboard.Grid.Fit(layout)
+			if err != nil {
+				return err
+			}
+		}
+
+		if dashboard.Grid.Collision {
+			for id := range changed {
+				for _, other := range *all {
+					if other.Id != id && widgets[id].Layout.Overlaps(other.Layout) {
+						return models.ErrLayoutOverlap
+					}
+				}
+			}
+		}
+
+		payload := events.LayoutChangedPayload{DashboardId: dashboardId, Widgets: make([]events.WidgetLayoutPayload, 0, len(updates))}
+		for i, update := range updates {
+			model := widgets[update.WidgetId]
+			// the dashboard lock does not keep out config writes, which bump the version as well
+			model.Version, err = service.widgetUpdater.UpdateLayout(ctx, model.Id, model.Layout, update.Version)
+			if err != nil {
+				return service.conflict(ctx, &models.Widget{Id: model.Id}, update.Version, err)
+			}
+
+			result[i] = *model
+			payload.Widgets = append(payload.Widgets, events.WidgetLayoutPayload{WidgetId: model.Id, Layout: events.LayoutOf(model.Layout), Version: model.Version})
+		}
+
+		err = service.events.Emit(ctx, events.LayoutChanged, actorId, &dashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, dashboardId, actorId, events.LayoutChanged)
+	})
+
+	if errors.Is(err, models.ErrVersionConflict) {
+		// read again after the rollback, inside the transaction earlier widgets were already moved
+		result = result[:0]
+		for _, update := range updates {
+			model := &models.Widget{Id: update.WidgetId}
+			if service.widgetProvider.GetWidget(ctx, model) == nil {
+				result = append(result, *model)
+			}
+		}
+	}
+
+	return result, err
+}
+
+func (service *Service) UpdateConfig(ctx context.Context, id int, config string, version int, actorId int) (*models.Widget, error) {
+	model := &models.Widget{Id: id}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		err = service.writable(ctx, model.DashboardId)
+		if err != nil {
+			return err
+		}
+
+		err = model.WidgetType.ValidateConfig(config)
+		if err != nil {
+			return err
+		}
+
+		_, err = service.widgetUpdater.UpdateConfig(ctx, id, config, version)
+		if err != nil {
+			return service.conflict(ctx, model, version, err)
+		}
+
+		err = service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		payload := events.WidgetConfigPayload{WidgetId: id, Config: json.RawMessage(model.Config), Version: model.Version}
+		err = service.events.Emit(ctx, events.WidgetUpdateConfig, actorId, &model.DashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.DashboardId, actorId, events.WidgetUpdateConfig)
+	})
+
+	return model, err
+}
+
+// PatchConfig applies a merge or JSON patch to the stored config. Without a version (0) the patch is applied to
+// whatever config is current, re-reading it if a concurrent write gets in between, so edits to different keys
+// never conflict. With a version it behaves like UpdateConfig.
+func (service *Service) PatchConfig(ctx context.Context, id int, format models.ConfigPatch, patch []byte, version int, actorId int) (*models.Widget, error) {
+	for attempt := 1; ; attempt++ {
+		model, err := service.patchConfig(ctx, id, format, patch, version, actorId)
+		if !errors.Is(err, errConfigChanged) || attempt == patchAttempts {
+			return model, err
+		}
+	}
+}
+
+func (service *Service) patchConfig(ctx context.Context, id int, format models.ConfigPatch, patch []byte, version int, actorId int) (*models.Widget, error) {
+	model := &models.Widget{Id: id}
+
+	err := service.transactor.WithTx(ctx, func(ctx context.Context) error {
+		err := service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+		if version != 0 && model.Version != version {
+			return models.ErrVersionConflict
+		}
+
+		err = service.writable(ctx, model.DashboardId)
+		if err != nil {
+			return err
+		}
+
+		config, err := applyPatch(format, []byte(model.Config), patch)
+		if err != nil {
+			return err
+		}
+
+		err = model.WidgetType.ValidateConfig(string(config))
+		if err != nil {
+			return err
+		}
+
+		_, err = service.widgetUpdater.UpdateConfig(ctx, id, string(config), model.Version)
+		if err != nil {
+			if version == 0 && service.widgetProvider.GetWidget(ctx, model) == nil {
+				return errConfigChanged
+			}
+			return service.conflict(ctx, model, version, err)
+		}
+
+		err = service.widgetProvider.GetWidget(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		payload := events.WidgetPatchPayload{WidgetId: id, Format: format, Patch: json.RawMessage(patch), Version: model.Version}
+		err = service.events.Emit(ctx, events.WidgetPatchConfig, actorId, &model.DashboardId, payload)
+		if err != nil {
+			return err
+		}
+
+		return service.history.Record(ctx, model.DashboardId, actorId, events.WidgetPatchConfig)
+	})
+
+	return model, err
+}
+
+func applyPatch(format models.ConfigPatch, config []byte, patch []byte) ([]byte, error) {
+	var result []byte
+	var err error
+
+	switch format {
+	case models.MergePatch:
+		result, err = jsonpatch.MergePatch(config, patch)
+	case models.JSONPatch:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			result, err = operations.Apply(config)
+		}
+	default:
+		return nil, models.ErrInvalidPatch
+	}
+
 	if err != nil {
-		return nil
+		return nil, errors.Join(models.ErrInvalidPatch, err)
+	}
+
+	return result, nil
+}
+
+// writable fails with models.ErrProvisioned for widgets of a provisioned dashboard, their definition file owns them.
+func (service *Service) writable(ctx context.Context, dashboardId int) error {
+	dashboard := &models.Dashboard{Id: dashboardId}
+	err := service.widgetProvider.GetDashboard(ctx, dashboard)
+	if err != nil {
+		return err
+	}
+	if dashboard.ProvisionKey != nil {
+		return models.ErrProvisioned
 	}
 
 	return nil
 }
 
+// conflict tells a stale version apart from a missing widget after a conditional update matched no row,
+// loading the current state into model for the former.
+func (service *Service) conflict(ctx context.Context, model *models.Widget, version int, err error) error {
+	if version != 0 && service.widgetProvider.GetWidget(ctx, model) == nil {
+		return models.ErrVersionConflict
+	}
+
+	return err
+}
+
 func (service *Service) GetByDashboard(ctx context.Context, userId int, dashboardId int) (*[]join_models.WidgetWithRight, error) {
 	result, err := service.widgetProvider.GetWidgetsByDashboard(ctx, userId, dashboardId)
 	if err != nil {
@@ -6,17 +6,30 @@ import (
 	join_models "nsi/internal/domain/join"
 )
 
+const dashboardColumns = "d.id, d.name, d.parentId, d.version, d.gridColumns, d.gridRowHeight, d.gridSnap, d.gridCollision, d.provisionKey"
+
+// dashboardTargets are the Scan destinations matching dashboardColumns.
+func dashboardTargets(model *models.Dashboard) []any {
+	grid := &model.Grid
+	return []any{&model.Id, &model.Name, &model.ParentId, &model.Version, &grid.Columns, &grid.RowHeight, &grid.Snap, &grid.Collision, &model.ProvisionKey}
+}
+
 func (s *Storage) CreateDashboard(ctx context.Context, model *models.Dashboard) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer conn.Release()
+	defer release()
 
-	query := "INSERT INTO dashboards (name, parentId) VALUES ($1, $2) RETURNING id;"
-	row := conn.QueryRow(ctx, query, model.Name, model.ParentId)
-	if err := row.Scan(&model.Id); err != nil {
+	query := `
+        INSERT INTO dashboards (name, parentId, gridColumns, gridRowHeight, gridSnap, gridCollision, provisionKey)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, version;
+    `
+	grid := model.Grid
+	row := conn.QueryRow(ctx, query, model.Name, model.ParentId, grid.Columns, grid.RowHeight, grid.Snap, grid.Collision, model.ProvisionKey)
+	if err := row.Scan(&model.Id, &model.Version); err != nil {
 		return err
 	}
 
@@ -24,12 +37,12 @@ func (s *Storage) CreateDashboard(ctx context.Context, model *models.Dashboard)
 }
 
 func (s *Storage) DeleteDashboard(ctx context.Context, id int) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer conn.Release()
+	defer release()
 
 	query := "DELETE FROM dashboards WHERE id=$1;"
 	_, err = conn.Exec(ctx, query, id)
@@ -38,36 +51,102 @@ func (s *Storage) DeleteDashboard(ctx context.Context, id int) error {
 }
 
 func (s *Storage) GetDashboard(ctx context.Context, model *models.Dashboard) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer conn.Release()
+	defer release()
 
-	query := "SELECT d.* FROM dashboards d WHERE d.id=$1;"
+	query := "SELECT " + dashboardColumns + " FROM dashboards d WHERE d.id=$1;"
 
 	row := conn.QueryRow(ctx, query, model.Id)
-	if err := row.Scan(&model.Id, &model.Name, &model.ParentId); err != nil {
+	if err := row.Scan(dashboardTargets(model)...); err != nil {
 		return err
 	}
 
 	return err
 }
 
+// GetDashboardByProvisionKey loads the dashboard provisioned under model.ProvisionKey.
+func (s *Storage) GetDashboardByProvisionKey(ctx context.Context, model *models.Dashboard) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "SELECT " + dashboardColumns + " FROM dashboards d WHERE d.provisionKey=$1;"
+
+	row := conn.QueryRow(ctx, query, model.ProvisionKey)
+	return row.Scan(dashboardTargets(model)...)
+}
+
+func (s *Storage) GetProvisionKeys(ctx context.Context) ([]string, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	query := "SELECT d.provisionKey FROM dashboards d WHERE d.provisionKey IS NOT NULL ORDER BY d.provisionKey;"
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []string
+	for rows.Next() {
+		var item string
+		if err := rows.Scan(&item); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
+func (s *Storage) GetDashboardChildren(ctx context.Context, id int) ([]models.Dashboard, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	query := "SELECT " + dashboardColumns + " FROM dashboards d WHERE d.parentId=$1 ORDER BY d.id;"
+	rows, err := conn.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.Dashboard
+	for rows.Next() {
+		var item models.Dashboard
+		if err := rows.Scan(dashboardTargets(&item)...); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
 func (s *Storage) GetDashboardsWithRights(ctx context.Context, userId int) ([]join_models.DashboardWithRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Release()
+	defer release()
 
-	query := `
-        SELECT d.id, d.name, ar.type 
-        FROM dashboards d
-        JOIN dashboardOnAccessRights dar ON d.id = dar.dashboardId
-        JOIN accessRights ar ON dar.accessRightId = ar.id
-        WHERE ar.userId = $1;
+	query := `WITH RECURSIVE ` + effectiveDashboardRights + `
+        SELECT DISTINCT ON (d.id) d.id, d.name, d.parentId, e.type 
+        FROM effective e
+        JOIN dashboards d ON d.id = e.id
+        ORDER BY d.id, e.type DESC, e.depth ASC;
     `
 	rows, err := conn.Query(ctx, query, userId)
 	if err != nil {
@@ -78,7 +157,7 @@ func (s *Storage) GetDashboardsWithRights(ctx context.Context, userId int) ([]jo
 	var results []join_models.DashboardWithRight
 	for rows.Next() {
 		var item join_models.DashboardWithRight
-		if err := rows.Scan(&item.Id, &item.Name, &item.AccessType); err != nil {
+		if err := rows.Scan(&item.Id, &item.Name, &item.ParentId, &item.AccessType); err != nil {
 			return nil, err
 		}
 		results = append(results, item)
@@ -86,20 +165,158 @@ func (s *Storage) GetDashboardsWithRights(ctx context.Context, userId int) ([]jo
 	return results, nil
 }
 
+func (s *Storage) GetDashboardTree(ctx context.Context, userId int) ([]join_models.DashboardTreeNode, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `WITH RECURSIVE ` + effectiveDashboardRights + `,
+        visible AS (
+            SELECT d.id, d.name, d.parentId, e.type
+            FROM effective e
+            JOIN dashboards d ON d.id = e.id
+        ),
+        ancestors AS (
+            SELECT p.id, p.parentId
+            FROM dashboards p
+            JOIN visible v ON p.id = v.parentId
+            UNION
+            SELECT p.id, p.parentId
+            FROM dashboards p
+            JOIN ancestors a ON p.id = a.parentId
+        ),
+        nodes AS (
+            SELECT DISTINCT ON (t.id) t.id, t.name, t.parentId, t.type
+            FROM (
+                SELECT id, name, parentId, type FROM visible
+                UNION ALL
+                SELECT id, '', parentId, NULL::grantType FROM ancestors
+            ) t
+            ORDER BY t.id, t.type DESC NULLS LAST
+        )
+        SELECT id, name, parentId, type FROM nodes ORDER BY name, id;
+    `
+	rows, err := conn.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []join_models.DashboardTreeNode
+	for rows.Next() {
+		var item join_models.DashboardTreeNode
+		if err := rows.Scan(&item.Id, &item.Name, &item.ParentId, &item.AccessType); err != nil {
+			return nil, err
+		}
+		item.Placeholder = item.AccessType == nil
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
 func (s *Storage) GetDashboardRightByData(ctx context.Context, userId int, dashboardId int) (*models.AccessRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Release()
+	defer release()
 	var result models.AccessRight
 
-	query := "SELECT ar.* FROM accessRights ar JOIN dashboardOnAccessRights d ON ar.id=d.accessRightId WHERE d.dashboardId=$1 AND ar.userId=$2;"
-	row := conn.QueryRow(ctx, query, dashboardId, userId)
+	query := `WITH RECURSIVE ` + effectiveDashboardRights + `
+        SELECT ar.id, ar.userId, ar.userGroupId, ar.accessToken, ar.type
+        FROM effective e
+        JOIN accessRights ar ON ar.id = e.rightId
+        WHERE e.id = $2
+        ORDER BY e.type DESC, e.depth ASC
+        LIMIT 1;
+    `
+	row := conn.QueryRow(ctx, query, userId, dashboardId)
 	if err := row.Scan(&result.Id, &result.UserId, &result.UserGroupId, &result.AccessToken, &result.Type); err != nil {
 		return nil, err
 	}
 
 	return &result, nil
 }
+
+// UpdateDashboard only applies when model.Version is current (or 0), model.Version then holds the new version.
+// A stale version reports pgx.ErrNoRows just like a missing dashboard.
+func (s *Storage) UpdateDashboard(ctx context.Context, model *models.Dashboard) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := `
+        UPDATE dashboards
+        SET name = $1, parentId = $2, gridColumns = $5, gridRowHeight = $6, gridSnap = $7, gridCollision = $8,
+            version = version + 1
+        WHERE id = $3 AND ($4 = 0 OR version = $4)
+        RETURNING version;
+    `
+	grid := model.Grid
+	return conn.QueryRow(ctx, query, model.Name, model.ParentId, model.Id, model.Version,
+		grid.Columns, grid.RowHeight, grid.Snap, grid.Collision).Scan(&model.Version)
+}
+
+// DashboardNameExists reports whether a sibling under parentId (the root when nil) is already called name.
+func (s *Storage) DashboardNameExists(ctx context.Context, parentId *int, name string) (bool, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	defer release()
+
+	query := "SELECT EXISTS (SELECT 1 FROM dashboards d WHERE d.name = $1 AND d.parentId IS NOT DISTINCT FROM $2);"
+
+	var result bool
+	if err := conn.QueryRow(ctx, query, name, parentId).Scan(&result); err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+// LockDashboard takes a row lock on the dashboard for the rest of the transaction, layout changes hold it so
+// collision checks see every concurrent move.
+func (s *Storage) LockDashboard(ctx context.Context, id int) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "SELECT d.id FROM dashboards d WHERE d.id=$1 FOR UPDATE;"
+	return conn.QueryRow(ctx, query, id).Scan(&id)
+}
+
+// IsDashboardDescendant reports whether id lies in the subtree rooted at ancestorId (ancestorId itself included).
+func (s *Storage) IsDashboardDescendant(ctx context.Context, ancestorId int, id int) (bool, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	defer release()
+
+	query := `
+        WITH RECURSIVE subtree AS (
+            SELECT d.id FROM dashboards d WHERE d.id = $1
+            UNION
+            SELECT d.id FROM dashboards d JOIN subtree s ON d.parentId = s.id
+        )
+        SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2);
+    `
+	var result bool
+	if err := conn.QueryRow(ctx, query, ancestorId, id).Scan(&result); err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package psql
+
+import (
+	"context"
+	models "nsi/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Storage) CreateGroup(ctx context.Context, model *models.UserGroup) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "INSERT INTO userGroups (name, ownerId) VALUES ($1, $2) RETURNING id;"
+	row := conn.QueryRow(ctx, query, model.Name, model.OwnerId)
+	if err := row.Scan(&model.Id); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) GetGroup(ctx context.Context, model *models.UserGroup) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "SELECT g.id, g.name, g.ownerId FROM userGroups g WHERE g.id=$1;"
+
+	row := conn.QueryRow(ctx, query, model.Id)
+	if err := row.Scan(&model.Id, &model.Name, &model.OwnerId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) GetUserGroups(ctx context.Context, userId int) ([]models.UserGroup, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `
+        SELECT g.id, g.name, g.ownerId
+        FROM userGroups g
+        WHERE g.ownerId = $1
+        OR EXISTS (SELECT 1 FROM userGroupMembers m WHERE m.groupId = g.id AND m.userId = $1)
+        ORDER BY g.id;
+    `
+	rows, err := conn.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.UserGroup
+	for rows.Next() {
+		var item models.UserGroup
+		if err := rows.Scan(&item.Id, &item.Name, &item.OwnerId); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+	return results, nil
+}
+
+func (s *Storage) UpdateGroup(ctx context.Context, model *models.UserGroup) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "UPDATE userGroups SET name = $1 WHERE id = $2;"
+	tag, err := conn.Exec(ctx, query, model.Name, model.Id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteGroup(ctx context.Context, id int) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "DELETE FROM userGroups WHERE id=$1;"
+	_, err = conn.Exec(ctx, query, id)
+
+	return err
+}
+
+func (s *Storage) AddGroupMember(ctx context.Context, groupId int, userId int) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := `
+        INSERT INTO userGroupMembers (groupId, userId)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING;
+    `
+	_, err = conn.Exec(ctx, query, groupId, userId)
+
+	return err
+}
+
+func (s *Storage) RemoveGroupMember(ctx context.Context, groupId int, userId int) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "DELETE FROM userGroupMembers WHERE groupId=$1 AND userId=$2;"
+	_, err = conn.Exec(ctx, query, groupId, userId)
+
+	return err
+}
+
+func (s *Storage) GetGroupMembers(ctx context.Context, groupId int) ([]int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := "SELECT m.userId FROM userGroupMembers m WHERE m.groupId = $1 ORDER BY m.userId;"
+	rows, err := conn.Query(ctx, query, groupId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]int, 0)
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		results = append(results, userId)
+	}
+	return results, nil
+}
@@ -0,0 +1,237 @@
+package psql
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key serializing migration runs across instances.
+const migrationLockKey = 727_001
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the embedded NNNN_name.up.sql / NNNN_name.down.sql pairs ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		file := entry.Name()
+
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %v: expected NNNN_name.up.sql or NNNN_name.down.sql", file)
+		}
+
+		rawVersion, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil {
+			return nil, fmt.Errorf("migration %v: invalid version: %w", file, err)
+		}
+
+		body, err := migrationFiles.ReadFile("migrations/" + file)
+		if err != nil {
+			return nil, err
+		}
+
+		item, ok := byVersion[version]
+		if !ok {
+			item = &migration{Version: version, Name: name}
+			byVersion[version] = item
+		}
+
+		if direction == "up" {
+			item.Up = string(body)
+		} else {
+			item.Down = string(body)
+		}
+	}
+
+	result := make([]migration, 0, len(byVersion))
+	for _, item := range byVersion {
+		if item.Up == "" || item.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%v: both up and down files are required", item.Version, item.Name)
+		}
+		result = append(result, *item)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+
+	return result, nil
+}
+
+// MigrateUp applies every pending migration in order and returns how many were applied.
+func (s *Storage) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, done map[int]time.Time) error {
+		for _, item := range migrations {
+			if _, ok := done[item.Version]; ok {
+				continue
+			}
+
+			s.log.Info("applying migration", slog.Int("version", item.Version), slog.String("name", item.Name))
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, item.Up); err != nil {
+					return err
+				}
+
+				_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2);", item.Version, item.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%v: %w", item.Version, item.Name, err)
+			}
+
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// MigrateDown reverts the last steps applied migrations and returns how many were reverted.
+func (s *Storage) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, done map[int]time.Time) error {
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			item := migrations[i]
+			if _, ok := done[item.Version]; !ok {
+				continue
+			}
+
+			s.log.Info("reverting migration", slog.Int("version", item.Version), slog.String("name", item.Name))
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, item.Down); err != nil {
+					return err
+				}
+
+				_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1;", item.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%v: %w", item.Version, item.Name, err)
+			}
+
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// MigrationStatus lists every known migration, AppliedAt stays nil for pending ones.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []MigrationStatus
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, done map[int]time.Time) error {
+		for _, item := range migrations {
+			status := MigrationStatus{Version: item.Version, Name: item.Name}
+			if appliedAt, ok := done[item.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			result = append(result, status)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// withMigrationLock holds the migration advisory lock on a dedicated connection while fn runs,
+// fn receives that connection and the applied versions read from schema_migrations.
+func (s *Storage) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn, done map[int]time.Time) error) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1);", migrationLockKey); err != nil {
+		return err
+	}
+
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", migrationLockKey)
+
+	query := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version int PRIMARY KEY,
+            name varchar(255) NOT NULL,
+            appliedAt timestamptz NOT NULL DEFAULT now()
+        );
+    `
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version, appliedAt FROM schema_migrations;")
+	if err != nil {
+		return err
+	}
+
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		done[version] = appliedAt
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
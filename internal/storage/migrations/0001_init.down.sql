@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS widgetOnAccessRights;
+DROP TABLE IF EXISTS dashboardOnAccessRights;
+DROP TABLE IF EXISTS accessRights;
+DROP TYPE IF EXISTS grantType;
+DROP TABLE IF EXISTS widgets;
+DROP TYPE IF EXISTS widgetType;
+DROP TABLE IF EXISTS dashboards;
@@ -1,12 +1,15 @@
-CREATE TABLE dashboards (
+CREATE TABLE IF NOT EXISTS dashboards (
     id SERIAL PRIMARY KEY,
     name varchar(255),
     parentId int NULL REFERENCES dashboards
 );
 
-CREATE TYPE widgetType AS ENUM ('square');
+DO $$ BEGIN
+    CREATE TYPE widgetType AS ENUM ('square');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
 
-CREATE TABLE widgets (
+CREATE TABLE IF NOT EXISTS widgets (
     id SERIAL PRIMARY KEY,
     name varchar(255),
     dashboardId int NOT NULL REFERENCES dashboards ON DELETE CASCADE,
@@ -14,9 +17,12 @@ CREATE TABLE widgets (
     config jsonb NOT NULL
 );
 
-CREATE TYPE grantType AS ENUM ('read', 'update', 'admin');
+DO $$ BEGIN
+    CREATE TYPE grantType AS ENUM ('read', 'update', 'admin');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
 
-CREATE TABLE accessRights (
+CREATE TABLE IF NOT EXISTS accessRights (
     id SERIAL PRIMARY KEY,
     userId int NULL,
     userGroupId int NULL,
@@ -24,14 +30,14 @@ CREATE TABLE accessRights (
     type grantType NOT NULL
 );
 
-CREATE TABLE dashboardOnAccessRights (
+CREATE TABLE IF NOT EXISTS dashboardOnAccessRights (
     accessRightId INT REFERENCES accessRights(id) ON DELETE CASCADE,
     dashboardId INT REFERENCES dashboards(id) ON DELETE CASCADE,
     PRIMARY KEY (accessRightId, dashboardId)
 );
 
-CREATE TABLE widgetOnAccessRights (
+CREATE TABLE IF NOT EXISTS widgetOnAccessRights (
     accessRightId INT REFERENCES accessRights(id) ON DELETE CASCADE,
     widgetId INT REFERENCES widgets(id) ON DELETE CASCADE,
     PRIMARY KEY (accessRightId, widgetId)
-);
\ No newline at end of file
+);
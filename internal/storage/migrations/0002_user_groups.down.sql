@@ -0,0 +1,4 @@
+ALTER TABLE accessRights DROP CONSTRAINT accessRights_userGroupId_fkey;
+
+DROP TABLE userGroupMembers;
+DROP TABLE userGroups;
@@ -0,0 +1,14 @@
+CREATE TABLE userGroups (
+    id SERIAL PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    ownerId int NOT NULL
+);
+
+CREATE TABLE userGroupMembers (
+    groupId INT REFERENCES userGroups(id) ON DELETE CASCADE,
+    userId INT NOT NULL,
+    PRIMARY KEY (groupId, userId)
+);
+
+ALTER TABLE accessRights
+    ADD CONSTRAINT accessRights_userGroupId_fkey FOREIGN KEY (userGroupId) REFERENCES userGroups(id) ON DELETE CASCADE;
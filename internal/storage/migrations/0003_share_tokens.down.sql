@@ -0,0 +1,3 @@
+ALTER TABLE accessRights
+    DROP CONSTRAINT accessRights_accessToken_key,
+    DROP COLUMN expiresAt;
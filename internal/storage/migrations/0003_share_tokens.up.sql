@@ -0,0 +1,3 @@
+ALTER TABLE accessRights
+    ADD COLUMN expiresAt timestamptz NULL,
+    ADD CONSTRAINT accessRights_accessToken_key UNIQUE (accessToken);
@@ -0,0 +1,12 @@
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic varchar(255) NOT NULL,
+    payload text NOT NULL,
+    createdAt timestamptz NOT NULL DEFAULT now(),
+    attempts int NOT NULL DEFAULT 0,
+    nextAttemptAt timestamptz NOT NULL DEFAULT now(),
+    lastError text NULL,
+    sentAt timestamptz NULL
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (nextAttemptAt, id) WHERE sentAt IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE widgets DROP COLUMN IF EXISTS version;
+ALTER TABLE dashboards DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+ALTER TABLE dashboards ADD COLUMN IF NOT EXISTS version int NOT NULL DEFAULT 1;
+ALTER TABLE widgets ADD COLUMN IF NOT EXISTS version int NOT NULL DEFAULT 1;
@@ -0,0 +1,6 @@
+-- enum values cannot be dropped, the type is rebuilt instead. This fails while widgets of the new types exist,
+-- delete or convert them first.
+ALTER TYPE widgetType RENAME TO widgetType_old;
+CREATE TYPE widgetType AS ENUM ('square');
+ALTER TABLE widgets ALTER COLUMN type TYPE widgetType USING type::text::widgetType;
+DROP TYPE widgetType_old;
@@ -0,0 +1,7 @@
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'line_chart';
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'bar_chart';
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'pie_chart';
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'table';
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'markdown_text';
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'single_stat';
+ALTER TYPE widgetType ADD VALUE IF NOT EXISTS 'image';
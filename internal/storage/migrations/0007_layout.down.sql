@@ -0,0 +1,16 @@
+UPDATE widgets SET config = jsonb_set(config, '{position}', jsonb_build_object('x', x, 'y', y))
+WHERE jsonb_typeof(config) = 'object';
+
+ALTER TABLE widgets
+    DROP COLUMN IF EXISTS x,
+    DROP COLUMN IF EXISTS y,
+    DROP COLUMN IF EXISTS w,
+    DROP COLUMN IF EXISTS h,
+    DROP COLUMN IF EXISTS z,
+    DROP COLUMN IF EXISTS locked;
+
+ALTER TABLE dashboards
+    DROP COLUMN IF EXISTS gridColumns,
+    DROP COLUMN IF EXISTS gridRowHeight,
+    DROP COLUMN IF EXISTS gridSnap,
+    DROP COLUMN IF EXISTS gridCollision;
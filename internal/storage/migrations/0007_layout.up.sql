@@ -0,0 +1,23 @@
+ALTER TABLE widgets
+    ADD COLUMN IF NOT EXISTS x double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS y double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS w double precision NOT NULL DEFAULT 4,
+    ADD COLUMN IF NOT EXISTS h double precision NOT NULL DEFAULT 3,
+    ADD COLUMN IF NOT EXISTS z int NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked boolean NOT NULL DEFAULT false;
+
+-- the position used to live in the config
+UPDATE widgets SET
+    x = COALESCE((config #>> '{position,x}')::double precision, 0),
+    y = COALESCE((config #>> '{position,y}')::double precision, 0),
+    config = config - 'position'
+WHERE jsonb_typeof(config) = 'object' AND config ? 'position';
+
+ALTER TABLE dashboards
+    ADD COLUMN IF NOT EXISTS gridColumns int NOT NULL DEFAULT 12,
+    ADD COLUMN IF NOT EXISTS gridRowHeight int NOT NULL DEFAULT 30,
+    ADD COLUMN IF NOT EXISTS gridSnap boolean NOT NULL DEFAULT true,
+    ADD COLUMN IF NOT EXISTS gridCollision boolean NOT NULL DEFAULT true;
+
+-- existing dashboards were laid out freely, they keep doing so until switched over
+UPDATE dashboards SET gridSnap = false, gridCollision = false;
@@ -0,0 +1,7 @@
+ALTER TABLE widgets
+    DROP CONSTRAINT widgets_dashboardId_provisionKey_key,
+    DROP COLUMN provisionKey;
+
+ALTER TABLE dashboards
+    DROP CONSTRAINT dashboards_provisionKey_key,
+    DROP COLUMN provisionKey;
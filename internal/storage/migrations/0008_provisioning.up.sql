@@ -0,0 +1,8 @@
+-- dashboards applied from definition files, the key ties them (and their widgets) to the definition
+ALTER TABLE dashboards
+    ADD COLUMN provisionKey text NULL,
+    ADD CONSTRAINT dashboards_provisionKey_key UNIQUE (provisionKey);
+
+ALTER TABLE widgets
+    ADD COLUMN provisionKey text NULL,
+    ADD CONSTRAINT widgets_dashboardId_provisionKey_key UNIQUE (dashboardId, provisionKey);
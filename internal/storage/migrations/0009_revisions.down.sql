@@ -0,0 +1,2 @@
+DROP TABLE dashboardRevisions;
+DROP TABLE dashboardRevisionCounters;
@@ -0,0 +1,17 @@
+-- every change of a dashboard or its widgets leaves a snapshot of the dashboard (an export document without
+-- children). Versions come from a counter row of their own, taken last in a transaction it cannot deadlock with
+-- the dashboard and widget locks taken before.
+CREATE TABLE dashboardRevisionCounters (
+    dashboardId int PRIMARY KEY REFERENCES dashboards ON DELETE CASCADE,
+    lastVersion int NOT NULL
+);
+
+CREATE TABLE dashboardRevisions (
+    dashboardId int NOT NULL REFERENCES dashboards ON DELETE CASCADE,
+    version int NOT NULL,
+    authorId int NOT NULL,
+    change varchar(255) NOT NULL,
+    snapshot jsonb NOT NULL,
+    createdAt timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (dashboardId, version)
+);
@@ -0,0 +1,100 @@
+package psql
+
+import (
+	"context"
+	models "nsi/internal/domain"
+	"time"
+)
+
+// EnqueueEvent stores an event for the outbox relay, it joins the transaction bound to ctx if any.
+func (s *Storage) EnqueueEvent(ctx context.Context, topic string, payload []byte) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "INSERT INTO outbox (topic, payload) VALUES ($1, $2);"
+	_, err = conn.Exec(ctx, query, topic, string(payload))
+
+	return err
+}
+
+// FetchPendingEvents locks up to limit due events, call it inside WithTx so concurrent relays skip them.
+func (s *Storage) FetchPendingEvents(ctx context.Context, limit int) ([]models.OutboxMessage, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `
+        SELECT o.id, o.topic, o.payload, o.attempts
+        FROM outbox o
+        WHERE o.sentAt IS NULL AND o.nextAttemptAt <= now()
+        ORDER BY o.nextAttemptAt, o.id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED;
+    `
+	rows, err := conn.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.OutboxMessage
+	for rows.Next() {
+		var item models.OutboxMessage
+		var payload string
+		if err := rows.Scan(&item.Id, &item.Topic, &payload, &item.Attempts); err != nil {
+			return nil, err
+		}
+		item.Payload = []byte(payload)
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
+func (s *Storage) MarkEventSent(ctx context.Context, id int64) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "UPDATE outbox SET sentAt = now(), attempts = attempts + 1, lastError = NULL WHERE id = $1;"
+	_, err = conn.Exec(ctx, query, id)
+
+	return err
+}
+
+func (s *Storage) MarkEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "UPDATE outbox SET attempts = attempts + 1, nextAttemptAt = $1, lastError = $2 WHERE id = $3;"
+	_, err = conn.Exec(ctx, query, nextAttemptAt, reason, id)
+
+	return err
+}
+
+// PurgeSentEvents deletes events delivered before the given moment.
+func (s *Storage) PurgeSentEvents(ctx context.Context, before time.Time) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "DELETE FROM outbox WHERE sentAt IS NOT NULL AND sentAt < $1;"
+	_, err = conn.Exec(ctx, query, before)
+
+	return err
+}
@@ -5,6 +5,8 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -37,3 +39,67 @@ func New(log *slog.Logger, connect string) (*Storage, error) {
 		dbPool: dbPool,
 	}, nil
 }
+
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+type afterCommitKey struct{}
+
+// WithTx runs fn inside a transaction, storage calls made with the context passed to fn take part in it.
+// The transaction is committed when fn returns nil and rolled back otherwise, nested calls join the outer one.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var hooks []func()
+	if err := fn(context.WithValue(context.WithValue(ctx, txKey{}, tx), afterCommitKey{}, &hooks)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		hook()
+	}
+	return nil
+}
+
+// AfterCommit runs fn once the transaction bound to ctx has committed, or right away outside of one. fn is
+// dropped when the transaction rolls back.
+func (s *Storage) AfterCommit(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(afterCommitKey{}).(*[]func()); ok {
+		*hooks = append(*hooks, fn)
+		return
+	}
+
+	fn()
+}
+
+// acquire returns the transaction bound to ctx or a pooled connection, release must be called once done.
+func (s *Storage) acquire(ctx context.Context) (querier, func(), error) {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx, func() {}, nil
+	}
+
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, conn.Release, nil
+}
@@ -0,0 +1,88 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	models "nsi/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateRevision stores revision under the next version of its dashboard and fills in Version and CreatedAt.
+func (s *Storage) CreateRevision(ctx context.Context, revision *models.Revision) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := `
+        WITH counter AS (
+            INSERT INTO dashboardRevisionCounters (dashboardId, lastVersion) VALUES ($1, 1)
+            ON CONFLICT (dashboardId) DO UPDATE SET lastVersion = dashboardRevisionCounters.lastVersion + 1
+            RETURNING lastVersion
+        )
+        INSERT INTO dashboardRevisions (dashboardId, version, authorId, change, snapshot)
+        SELECT $1, c.lastVersion, $2, $3, $4 FROM counter c
+        RETURNING version, createdAt;
+    `
+	row := conn.QueryRow(ctx, query, revision.DashboardId, revision.AuthorId, revision.Change, string(revision.Snapshot))
+	return row.Scan(&revision.Version, &revision.CreatedAt)
+}
+
+// GetRevisions lists the revisions of a dashboard newest first, without their snapshots.
+func (s *Storage) GetRevisions(ctx context.Context, dashboardId int) ([]models.Revision, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `
+        SELECT r.version, r.dashboardId, r.authorId, r.change, r.createdAt
+        FROM dashboardRevisions r
+        WHERE r.dashboardId = $1
+        ORDER BY r.version DESC;
+    `
+	rows, err := conn.Query(ctx, query, dashboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.Revision{}
+	for rows.Next() {
+		var item models.Revision
+		if err := rows.Scan(&item.Version, &item.DashboardId, &item.AuthorId, &item.Change, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
+// GetRevision loads revision.Version of revision.DashboardId with its snapshot, models.ErrRevisionNotFound when
+// there is no such revision.
+func (s *Storage) GetRevision(ctx context.Context, revision *models.Revision) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := `
+        SELECT r.authorId, r.change, r.createdAt, r.snapshot
+        FROM dashboardRevisions r
+        WHERE r.dashboardId = $1 AND r.version = $2;
+    `
+	var snapshot string
+	err = conn.QueryRow(ctx, query, revision.DashboardId, revision.Version).Scan(&revision.AuthorId, &revision.Change, &revision.CreatedAt, &snapshot)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrRevisionNotFound
+	}
+	revision.Snapshot = []byte(snapshot)
+
+	return err
+}
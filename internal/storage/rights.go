@@ -5,16 +5,47 @@ import (
 	models "nsi/internal/domain"
 )
 
+// grantedToUser matches access rights (aliased ar) held by the user bound to $1, directly or through a group.
+const grantedToUser = `(ar.userId = $1 OR ar.userGroupId IN (SELECT m.groupId FROM userGroupMembers m WHERE m.userId = $1))`
+
+// grantedToToken matches unexpired share tokens (aliased ar) equal to $1.
+const grantedToToken = `ar.accessToken = $1 AND (ar.expiresAt IS NULL OR ar.expiresAt > now())`
+
+// dashboardAncestors is a recursive CTE member walking up the parentId chain from the dashboard bound to $2.
+const dashboardAncestors = `
+    chain AS (
+        SELECT d.id, d.parentId, 0 AS depth FROM dashboards d WHERE d.id = $2
+        UNION ALL
+        SELECT p.id, p.parentId, c.depth + 1
+        FROM dashboards p
+        JOIN chain c ON p.id = c.parentId
+    )`
+
+// effectiveDashboardRights is a recursive CTE member listing every dashboard the user bound to $1 can reach:
+// each direct grant is pushed down the parentId chain, depth being the distance to the granting dashboard.
+// Callers pick the strongest type per dashboard and break ties with the smallest depth.
+const effectiveDashboardRights = `
+    effective AS (
+        SELECT dar.dashboardId AS id, ar.id AS rightId, ar.type, 0 AS depth
+        FROM accessRights ar
+        JOIN dashboardOnAccessRights dar ON dar.accessRightId = ar.id
+        WHERE ` + grantedToUser + `
+        UNION ALL
+        SELECT d.id, e.rightId, e.type, e.depth + 1
+        FROM dashboards d
+        JOIN effective e ON d.parentId = e.id
+    )`
+
 func (s *Storage) GetAccessRightByData(ctx context.Context, userId int, id int) (*models.AccessRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Release()
+	defer release()
 	var result models.AccessRight
 
-	query := "SELECT ar.* FROM accessRights ar WHERE ar.Id=$1 AND ar.userId=$2;"
+	query := "SELECT ar.id, ar.userId, ar.userGroupId, ar.accessToken, ar.type FROM accessRights ar WHERE ar.Id=$1 AND ar.userId=$2;"
 	row := conn.QueryRow(ctx, query, id, userId)
 	if err := row.Scan(&result.Id, &result.UserId, &result.UserGroupId, &result.AccessToken, &result.Type); err != nil {
 		return nil, err
@@ -24,11 +55,11 @@ func (s *Storage) GetAccessRightByData(ctx context.Context, userId int, id int)
 }
 
 func (s *Storage) UpdateAccessRight(ctx context.Context, id int, update models.AccessRight) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
         UPDATE accessRights 
@@ -48,11 +79,11 @@ func (s *Storage) UpdateAccessRight(ctx context.Context, id int, update models.A
 }
 
 func (s *Storage) UpdateAccessRightType(ctx context.Context, id int, grant models.GrantType) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
         UPDATE accessRights 
@@ -69,43 +100,63 @@ func (s *Storage) UpdateAccessRightType(ctx context.Context, id int, grant model
 }
 
 func (s *Storage) DeleteDashboardAccessRight(ctx context.Context, dashboardId int, rightId int) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Release()
+	defer release()
 
-	query := `DELETE FROM accessRights
-	USING widgetOnAccessRights a
-	WHERE a.widgetId=$1 AND id=$2;`
+	query := `DELETE FROM accessRights ar
+	USING dashboardOnAccessRights a
+	WHERE a.accessRightId=ar.id AND a.dashboardId=$1 AND ar.id=$2;`
 	_, err = conn.Exec(ctx, query, dashboardId, rightId)
 	return err
 }
 
 func (s *Storage) DeleteWidgetAccessRight(ctx context.Context, widgetId int, rightId int) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Release()
+	defer release()
 
-	query := `DELETE FROM accessRights
-	USING dashboardOnAccessRights a
-	WHERE a.dashboardId=$1 AND id=$2;`
+	query := `DELETE FROM accessRights ar
+	USING widgetOnAccessRights a
+	WHERE a.accessRightId=ar.id AND a.widgetId=$1 AND ar.id=$2;`
 	_, err = conn.Exec(ctx, query, widgetId, rightId)
 	return err
 }
 
+// GetAccessRightDashboard returns the dashboard a right applies to, widget rights resolve to the widget's dashboard.
+func (s *Storage) GetAccessRightDashboard(ctx context.Context, id int) (int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	query := `
+        SELECT d.dashboardId FROM dashboardOnAccessRights d WHERE d.accessRightId = $1
+        UNION ALL
+        SELECT w.dashboardId FROM widgetOnAccessRights wr JOIN widgets w ON w.id = wr.widgetId WHERE wr.accessRightId = $1
+        LIMIT 1;
+    `
+	var dashboardId int
+	err = conn.QueryRow(ctx, query, id).Scan(&dashboardId)
+
+	return dashboardId, err
+}
+
 func (s *Storage) CreateAccessRight(ctx context.Context, right *models.AccessRight) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
-        INSERT INTO accessRights (userId, userGroupId, accessToken, type) 
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO accessRights (userId, userGroupId, accessToken, expiresAt, type) 
+        VALUES ($1, $2, $3, $4, $5)
         RETURNING id;
     `
 	return conn.QueryRow(
@@ -114,22 +165,23 @@ func (s *Storage) CreateAccessRight(ctx context.Context, right *models.AccessRig
 		right.UserId,
 		right.UserGroupId,
 		right.AccessToken,
+		right.ExpiresAt,
 		right.Type,
 	).Scan(&right.Id)
 }
 
 func (s *Storage) CreateDashboardAccessRight(ctx context.Context, dashboardId int, accessId int) (int, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return accessId, err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
         INSERT INTO dashboardOnAccessRights (accessRightId, dashboardId) 
         VALUES ($1, $2)
     `
-	_, err = conn.Query(
+	_, err = conn.Exec(
 		ctx,
 		query,
 		accessId,
@@ -140,17 +192,17 @@ func (s *Storage) CreateDashboardAccessRight(ctx context.Context, dashboardId in
 }
 
 func (s *Storage) CreateWidgetAccessRight(ctx context.Context, widgetId int, accessId int) (int, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return accessId, err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
         INSERT INTO widgetOnAccessRights (accessRightId, widgetId) 
         VALUES ($1, $2)
     `
-	_, err = conn.Query(
+	_, err = conn.Exec(
 		ctx,
 		query,
 		accessId,
@@ -161,14 +213,14 @@ func (s *Storage) CreateWidgetAccessRight(ctx context.Context, widgetId int, acc
 }
 
 func (s *Storage) GetDashboardRights(ctx context.Context, dashboardId int) ([]models.AccessRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
-        SELECT a.id, a.userId, a.userGroupId, a.accessToken, a.type
+        SELECT a.id, a.userId, a.userGroupId, a.accessToken, a.expiresAt, a.type
         FROM accessRights a
         JOIN dashboardOnAccessRights d ON d.accessRightId = a.id
 
@@ -183,7 +235,7 @@ func (s *Storage) GetDashboardRights(ctx context.Context, dashboardId int) ([]mo
 	var results []models.AccessRight
 	for rows.Next() {
 		var item models.AccessRight
-		if err := rows.Scan(&item.Id, &item.UserId, &item.UserGroupId, &item.AccessToken, &item.Type); err != nil {
+		if err := rows.Scan(&item.Id, &item.UserId, &item.UserGroupId, &item.AccessToken, &item.ExpiresAt, &item.Type); err != nil {
 			return nil, err
 		}
 		results = append(results, item)
@@ -192,14 +244,14 @@ func (s *Storage) GetDashboardRights(ctx context.Context, dashboardId int) ([]mo
 }
 
 func (s *Storage) GetWidgetRights(ctx context.Context, widgetdId int) ([]models.AccessRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Release()
+	defer release()
 
 	query := `
-        SELECT a.id, a.userId, a.userGroupId, a.accessToken, a.type
+        SELECT a.id, a.userId, a.userGroupId, a.accessToken, a.expiresAt, a.type
         FROM accessRights a
         JOIN widgetOnAccessRights d ON d.accessRightId = a.id
 
@@ -214,7 +266,7 @@ func (s *Storage) GetWidgetRights(ctx context.Context, widgetdId int) ([]models.
 	var results []models.AccessRight
 	for rows.Next() {
 		var item models.AccessRight
-		if err := rows.Scan(&item.Id, &item.UserId, &item.UserGroupId, &item.AccessToken, &item.Type); err != nil {
+		if err := rows.Scan(&item.Id, &item.UserId, &item.UserGroupId, &item.AccessToken, &item.ExpiresAt, &item.Type); err != nil {
 			return nil, err
 		}
 		results = append(results, item)
@@ -223,19 +275,26 @@ func (s *Storage) GetWidgetRights(ctx context.Context, widgetdId int) ([]models.
 }
 
 func (s *Storage) GetWidgetRightByData(ctx context.Context, userId int, widgetId int) (*models.AccessRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Release()
+	defer release()
 	var result models.AccessRight
 
-	query := `WITH widget_dash AS (
-		SELECT dashboardId
-		FROM widgets 
-		WHERE id = $1
-		LIMIT 1
+	// widget grants apply directly, dashboard grants only count when they are admin
+	query := `WITH RECURSIVE ` + effectiveDashboardRights + `,
+	candidates AS (
+		SELECT wor.accessRightId AS rightId, ar.type, 0 AS depth
+		FROM widgetOnAccessRights wor
+		JOIN accessRights ar ON ar.id = wor.accessRightId
+		WHERE wor.widgetId = $2 AND ` + grantedToUser + `
+		UNION ALL
+		SELECT e.rightId, e.type, e.depth + 1
+		FROM effective e
+		JOIN widgets w ON w.dashboardId = e.id
+		WHERE w.id = $2 AND e.type = 'admin'
 	)
 	SELECT 
 		ar.id, 
@@ -243,23 +302,158 @@ func (s *Storage) GetWidgetRightByData(ctx context.Context, userId int, widgetId
 		ar.usergroupId, 
 		ar.accesstoken, 
 		ar.type
-	FROM accessRights ar
-	LEFT JOIN widgetOnAccessRights wor 
-		ON wor.accessRightId = ar.id 
-		AND wor.widgetId = $1
-	LEFT JOIN dashboardOnAccessRights dor 
-		ON dor.accessRightId = ar.id
-		AND dor.dashboardId = (SELECT dashboardId FROM widget_dash)
-		AND ar.type = 'admin'
-	WHERE ar.userId = $2
-	AND (wor.widgetId IS NOT NULL OR dor.dashboardId IS NOT NULL)
-	ORDER BY 
-		CASE WHEN wor.widgetId IS NOT NULL THEN 0 ELSE 1 END
+	FROM candidates c
+	JOIN accessRights ar ON ar.id = c.rightId
+	ORDER BY c.type DESC, c.depth ASC
 	LIMIT 1;`
-	row := conn.QueryRow(ctx, query, widgetId, userId)
+	row := conn.QueryRow(ctx, query, userId, widgetId)
 	if err := row.Scan(&result.Id, &result.UserId, &result.UserGroupId, &result.AccessToken, &result.Type); err != nil {
 		return nil, err
 	}
 
 	return &result, nil
 }
+
+func (s *Storage) GetDashboardRightByToken(ctx context.Context, token string, dashboardId int) (*models.AccessRight, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+	var result models.AccessRight
+
+	query := `WITH RECURSIVE ` + dashboardAncestors + `
+	SELECT ar.id, ar.userId, ar.userGroupId, ar.accessToken, ar.expiresAt, ar.type
+	FROM chain c
+	JOIN dashboardOnAccessRights dar ON dar.dashboardId = c.id
+	JOIN accessRights ar ON ar.id = dar.accessRightId
+	WHERE ` + grantedToToken + `
+	ORDER BY ar.type DESC, c.depth ASC
+	LIMIT 1;`
+	row := conn.QueryRow(ctx, query, token, dashboardId)
+	if err := row.Scan(&result.Id, &result.UserId, &result.UserGroupId, &result.AccessToken, &result.ExpiresAt, &result.Type); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (s *Storage) GetWidgetRightByToken(ctx context.Context, token string, widgetId int) (*models.AccessRight, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dashboardId int
+
+	query := "SELECT w.dashboardId FROM widgets w WHERE w.id=$1;"
+	err = conn.QueryRow(ctx, query, widgetId).Scan(&dashboardId)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	// a share token covers every widget of the shared dashboard
+	return s.GetDashboardRightByToken(ctx, token, dashboardId)
+}
+
+// GetDashboardAudience lists users holding any right on the dashboard, its ancestors or its widgets,
+// directly or through a group. Share tokens are not included.
+func (s *Storage) GetDashboardAudience(ctx context.Context, dashboardId int) ([]int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `
+        WITH RECURSIVE chain AS (
+            SELECT d.id, d.parentId FROM dashboards d WHERE d.id = $1
+            UNION ALL
+            SELECT p.id, p.parentId
+            FROM dashboards p
+            JOIN chain c ON p.id = c.parentId
+        ),
+        grants AS (
+            SELECT ar.userId, ar.userGroupId
+            FROM chain c
+            JOIN dashboardOnAccessRights dar ON dar.dashboardId = c.id
+            JOIN accessRights ar ON ar.id = dar.accessRightId
+            UNION
+            SELECT ar.userId, ar.userGroupId
+            FROM widgets w
+            JOIN widgetOnAccessRights wor ON wor.widgetId = w.id
+            JOIN accessRights ar ON ar.id = wor.accessRightId
+            WHERE w.dashboardId = $1
+        )
+        SELECT g.userId FROM grants g WHERE g.userId IS NOT NULL
+        UNION
+        SELECT m.userId FROM grants g JOIN userGroupMembers m ON m.groupId = g.userGroupId;
+    `
+	rows, err := conn.Query(ctx, query, dashboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []int
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		results = append(results, userId)
+	}
+	return results, rows.Err()
+}
+
+// GetWidgetAudience lists users who can see any of the widgets: those holding a right on one of them and admins of
+// dashboardId or its ancestors, directly or through a group. Share tokens are not included.
+func (s *Storage) GetWidgetAudience(ctx context.Context, dashboardId int, widgetIds []int) ([]int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `
+        WITH RECURSIVE chain AS (
+            SELECT d.id, d.parentId FROM dashboards d WHERE d.id = $1
+            UNION ALL
+            SELECT p.id, p.parentId
+            FROM dashboards p
+            JOIN chain c ON p.id = c.parentId
+        ),
+        grants AS (
+            SELECT ar.userId, ar.userGroupId
+            FROM chain c
+            JOIN dashboardOnAccessRights dar ON dar.dashboardId = c.id
+            JOIN accessRights ar ON ar.id = dar.accessRightId
+            WHERE ar.type = 'admin'
+            UNION
+            SELECT ar.userId, ar.userGroupId
+            FROM widgetOnAccessRights wor
+            JOIN accessRights ar ON ar.id = wor.accessRightId
+            WHERE wor.widgetId = ANY($2)
+        )
+        SELECT g.userId FROM grants g WHERE g.userId IS NOT NULL
+        UNION
+        SELECT m.userId FROM grants g JOIN userGroupMembers m ON m.groupId = g.userGroupId;
+    `
+	rows, err := conn.Query(ctx, query, dashboardId, widgetIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []int
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		results = append(results, userId)
+	}
+	return results, rows.Err()
+}
@@ -6,17 +6,48 @@ import (
 	join_models "nsi/internal/domain/join"
 )
 
+const widgetLayoutColumns = "w.x, w.y, w.w, w.h, w.z, w.locked"
+
+// layoutTargets are the Scan destinations matching widgetLayoutColumns.
+func layoutTargets(layout *models.WidgetLayout) []any {
+	return []any{&layout.X, &layout.Y, &layout.W, &layout.H, &layout.Z, &layout.Locked}
+}
+
 func (s *Storage) CreateWidget(ctx context.Context, model *models.Widget) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer conn.Release()
+	defer release()
 
-	query := "INSERT INTO widgets (name, dashboardId, type, config) VALUES ($1, $2, $3, $4) RETURNING id;"
-	row := conn.QueryRow(ctx, query, model.Name, model.DashboardId, model.WidgetType, model.Config)
-	if err := row.Scan(&model.Id); err != nil {
+	query := `
+        INSERT INTO widgets (name, dashboardId, type, config, x, y, w, h, z, locked, provisionKey)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        RETURNING id, version;
+    `
+	layout := model.Layout
+	row := conn.QueryRow(ctx, query, model.Name, model.DashboardId, model.WidgetType, model.Config,
+		layout.X, layout.Y, layout.W, layout.H, layout.Z, layout.Locked, model.ProvisionKey)
+	if err := row.Scan(&model.Id, &model.Version); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) GetWidget(ctx context.Context, model *models.Widget) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := "SELECT w.id, w.name, w.dashboardId, w.type, w.config, w.version, w.provisionKey, " + widgetLayoutColumns + " FROM widgets w WHERE w.id=$1;"
+
+	row := conn.QueryRow(ctx, query, model.Id)
+	if err := row.Scan(append([]any{&model.Id, &model.Name, &model.DashboardId, &model.WidgetType, &model.Config, &model.Version, &model.ProvisionKey}, layoutTargets(&model.Layout)...)...); err != nil {
 		return err
 	}
 
@@ -24,12 +55,12 @@ func (s *Storage) CreateWidget(ctx context.Context, model *models.Widget) error
 }
 
 func (s *Storage) DeleteWidget(ctx context.Context, id int) error {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer conn.Release()
+	defer release()
 
 	query := "DELETE FROM widgets WHERE id=$1;"
 	_, err = conn.Exec(ctx, query, id)
@@ -38,17 +69,24 @@ func (s *Storage) DeleteWidget(ctx context.Context, id int) error {
 }
 
 func (s *Storage) GetWidgetsByDashboard(ctx context.Context, userId int, dashboardId int) (*[]join_models.WidgetWithRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Release()
+	defer release()
 
-	query := "SELECT w.id, w.dashboardId, w.type, w.config, access.type FROM widgets w JOIN widgetOnAccessRights wr ON w.id=wr.widgetId JOIN accessRights access ON access.id=wr.accessRightId WHERE w.dashboardId=$1 AND access.userId=$2;"
+	query := `
+        SELECT DISTINCT ON (w.id) w.id, w.name, w.dashboardId, w.type, w.config, w.version, w.provisionKey, ` + widgetLayoutColumns + `, ar.type
+        FROM widgets w
+        JOIN widgetOnAccessRights wr ON w.id = wr.widgetId
+        JOIN accessRights ar ON ar.id = wr.accessRightId
+        WHERE w.dashboardId = $2 AND ` + grantedToUser + `
+        ORDER BY w.id, ar.type DESC;
+    `
 
 	count := 0
-	rows, err := conn.Query(ctx, query, dashboardId, userId)
+	rows, err := conn.Query(ctx, query, userId, dashboardId)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +95,7 @@ func (s *Storage) GetWidgetsByDashboard(ctx context.Context, userId int, dashboa
 	}
 	result := make([]join_models.WidgetWithRight, 0, count)
 
-	rows, err = conn.Query(ctx, query, dashboardId, userId)
+	rows, err = conn.Query(ctx, query, userId, dashboardId)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +103,8 @@ func (s *Storage) GetWidgetsByDashboard(ctx context.Context, userId int, dashboa
 
 	for rows.Next() {
 		var item join_models.WidgetWithRight
-		if err := rows.Scan(&item.Id, &item.DashboardId, &item.WidgetType, &item.Config, &item.AccessType); err != nil {
+		targets := append([]any{&item.Id, &item.Name, &item.DashboardId, &item.WidgetType, &item.Config, &item.Version, &item.ProvisionKey}, layoutTargets(&item.Layout)...)
+		if err := rows.Scan(append(targets, &item.AccessType)...); err != nil {
 			return nil, err
 		}
 		result = append(result, item)
@@ -75,14 +114,14 @@ func (s *Storage) GetWidgetsByDashboard(ctx context.Context, userId int, dashboa
 }
 
 func (s *Storage) GetAllWidgetsByDashboard(ctx context.Context, dashboardId int) (*[]join_models.WidgetWithRight, error) {
-	conn, err := s.dbPool.Acquire(ctx)
+	conn, release, err := s.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Release()
+	defer release()
 
-	query := "SELECT w.id, w.dashboardId, w.type, w.config FROM widgets w WHERE w.dashboardId=$1;"
+	query := "SELECT w.id, w.name, w.dashboardId, w.type, w.config, w.version, w.provisionKey, " + widgetLayoutColumns + " FROM widgets w WHERE w.dashboardId=$1 ORDER BY w.id;"
 
 	rows, err := conn.Query(ctx, query, dashboardId)
 	if err != nil {
@@ -93,7 +132,7 @@ func (s *Storage) GetAllWidgetsByDashboard(ctx context.Context, dashboardId int)
 	var result []join_models.WidgetWithRight
 	for rows.Next() {
 		var item join_models.WidgetWithRight
-		if err := rows.Scan(&item.Id, &item.DashboardId, &item.WidgetType, &item.Config); err != nil {
+		if err := rows.Scan(append([]any{&item.Id, &item.Name, &item.DashboardId, &item.WidgetType, &item.Config, &item.Version, &item.ProvisionKey}, layoutTargets(&item.Layout)...)...); err != nil {
 			return nil, err
 		}
 		result = append(result, item)
@@ -102,34 +141,85 @@ func (s *Storage) GetAllWidgetsByDashboard(ctx context.Context, dashboardId int)
 	return &result, nil
 }
 
-func (s *Storage) UpdatePosition(id int, x, y float64) error {
+// UpdateLayout and UpdateConfig only apply when version is current (or 0) and return the new version,
+// a stale version reports pgx.ErrNoRows just like a missing widget.
+func (s *Storage) UpdateLayout(ctx context.Context, id int, layout models.WidgetLayout, version int) (int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
 	query := `
         UPDATE widgets
-		SET config = jsonb_set(
-			jsonb_set(
-				COALESCE(config, '{}')::jsonb, 
-				'{position, x}',
-				to_jsonb($1::float),
-				true 
-			),
-			'{position, y}',
-			to_jsonb($2::float),
-			true
-		)
-		WHERE id = $3;
+        SET x = $1, y = $2, w = $3, h = $4, z = $5, locked = $6, version = version + 1
+        WHERE id = $7 AND ($8 = 0 OR version = $8)
+        RETURNING version;
     `
 
-	_, err := s.dbPool.Exec(context.Background(), query, x, y, id)
-	return err
+	err = conn.QueryRow(ctx, query, layout.X, layout.Y, layout.W, layout.H, layout.Z, layout.Locked, id, version).Scan(&version)
+	return version, err
+}
+
+// UpdateWidget overwrites name, type, config and layout regardless of the version, model.Version then holds the
+// new one. Provisioning uses it to bring a widget back to its definition.
+func (s *Storage) UpdateWidget(ctx context.Context, model *models.Widget) error {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	query := `
+        UPDATE widgets
+        SET name = $1, type = $2, config = $3, x = $4, y = $5, w = $6, h = $7, z = $8, locked = $9, version = version + 1
+        WHERE id = $10
+        RETURNING version;
+    `
+
+	layout := model.Layout
+	return conn.QueryRow(ctx, query, model.Name, model.WidgetType, model.Config,
+		layout.X, layout.Y, layout.W, layout.H, layout.Z, layout.Locked, model.Id).Scan(&model.Version)
 }
 
-func (s *Storage) UpdateConfig(id int, config string) error {
+// MoveWidget puts the widget on another dashboard at layout, its rights stay with it.
+func (s *Storage) MoveWidget(ctx context.Context, id int, dashboardId int, layout models.WidgetLayout) (int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
+	query := `
+        UPDATE widgets
+        SET dashboardId = $1, x = $2, y = $3, w = $4, h = $5, z = $6, locked = $7, version = version + 1
+        WHERE id = $8
+        RETURNING version;
+    `
+
+	var version int
+	err = conn.QueryRow(ctx, query, dashboardId, layout.X, layout.Y, layout.W, layout.H, layout.Z, layout.Locked, id).Scan(&version)
+	return version, err
+}
+
+func (s *Storage) UpdateConfig(ctx context.Context, id int, config string, version int) (int, error) {
+	conn, release, err := s.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
 	query := `
         UPDATE widgets 
-        SET config = $1 
-        WHERE id = $2;
+        SET config = $1, version = version + 1
+        WHERE id = $2 AND ($3 = 0 OR version = $3)
+        RETURNING version;
     `
 
-	_, err := s.dbPool.Exec(context.Background(), query, config, id)
-	return err
+	err = conn.QueryRow(ctx, query, config, id, version).Scan(&version)
+	return version, err
 }